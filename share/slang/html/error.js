@@ -0,0 +1,57 @@
+(function(){
+  var report = {{.Report}};
+  
+  if(window.console && console.error){
+    console.error("Slang: "+ report.resource +": "+ report.message);
+  }
+  
+  var show = function(){
+    var overlay = document.createElement("div");
+    overlay.id = "slang-error-overlay";
+    overlay.setAttribute("style", "position: fixed; z-index: 2147483647; top: 0; left: 0; right: 0; bottom: 0; overflow: auto; background: #ECECEC; font-family: Monaco, 'Lucida Console', monospace; text-align: left;");
+    
+    var close = document.createElement("a");
+    close.href = "#";
+    close.textContent = "\u00d7";
+    close.title = "Dismiss";
+    close.setAttribute("style", "position: absolute; top: 12px; right: 20px; font-size: 28px; color: #fff; text-decoration: none;");
+    close.onclick = function(){ overlay.parentNode.removeChild(overlay); return false; };
+    overlay.appendChild(close);
+    
+    var header = document.createElement("h1");
+    header.textContent = report.header;
+    header.setAttribute("style", "margin: 0; background: #AD632A; padding: 20px 45px; color: #fff; border-bottom: 1px solid #9F5805; font-size: 28px;");
+    overlay.appendChild(header);
+    
+    var message = document.createElement("p");
+    message.textContent = report.resource +": "+ report.message;
+    message.setAttribute("style", "margin: 0; padding: 15px 45px; background: #F6A960; border-top: 4px solid #D29052; color: #733512; font-size: 14px; border-bottom: 1px solid #BA7F5B;");
+    overlay.appendChild(message);
+    
+    for(var i = 0; report.errors && i < report.errors.length; i++){
+      var e = report.errors[i];
+      var detail = document.createElement("pre");
+      detail.textContent = e.message;
+      detail.setAttribute("style", "margin: 0; padding: 15px 45px; font-size: 12px; border-bottom: 1px solid #ccc;");
+      overlay.appendChild(detail);
+      for(var j = 0; e.source && j < e.source.length; j++){
+        var line = document.createElement("pre");
+        line.innerHTML = e.source[j] +" "; // source lines are escaped by the server
+        line.setAttribute("style", "margin: 0; padding: 5px 54px; font-size: 12px; border-bottom: 1px solid #ccc;");
+        var markers = line.getElementsByClassName("marker");
+        for(var k = 0; k < markers.length; k++){
+          markers[k].setAttribute("style", "color: #fff; background: #a00;");
+        }
+        overlay.appendChild(line);
+      }
+    }
+    
+    document.body.appendChild(overlay);
+  }
+  
+  if(document.body){
+    show();
+  }else{
+    document.addEventListener("DOMContentLoaded", show);
+  }
+})();
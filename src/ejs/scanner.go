@@ -84,6 +84,13 @@ func (s *SourceError) Message() string {
   return s.error
 }
 
+/**
+ * Obtain the path of the resource in which the error occurred
+ */
+func (s *SourceError) Path() string {
+  return s.inpath
+}
+
 /**
  * Obtain the error line
  */
@@ -209,6 +216,34 @@ const (
   TokenTypeError        = -1
 )
 
+/**
+ * Scanner options
+ */
+const (
+  ScannerOptionNone     = 0
+  ScannerOptionLegacy   = 1 << 0 // directives are recognized at the start of any line, even in comments and strings
+)
+
+/**
+ * Javascript lexical contexts
+ */
+const (
+  lexCode               = iota
+  lexLineComment
+  lexBlockComment
+  lexString
+  lexTemplate
+  lexRegex
+)
+
+/**
+ * Words after which a '/' begins a regular expression rather than a division
+ */
+var regexKeywords = map[string]bool{
+  "return": true, "typeof": true, "instanceof": true, "in": true, "of": true, "new": true, "delete": true,
+  "void": true, "throw": true, "case": true, "do": true, "else": true, "yield": true, "await": true,
+}
+
 const eof = -1
 const delimiter = '#'
 
@@ -216,8 +251,9 @@ const delimiter = '#'
  * A token
  */
 type Token struct {
-  Type  int
-  Text  string
+  Type      int
+  Text      string
+  Integrity string
 }
 
 /**
@@ -231,13 +267,41 @@ type Scanner struct {
   width     int
   line      int
   column    int
+  dindex    int
+  dline     int
+  dcolumn   int
+  options   int
+  lex       lexer
+}
+
+/**
+ * The Javascript lexical state. We don't parse Javascript, but we lex just enough
+ * of it to know whether we're in a comment, string, template or regular expression
+ * literal, where directives are left alone.
+ */
+type lexer struct {
+  context   int
+  quote     rune
+  skip      int
+  class     bool
+  braces    []int
+  last      rune
+  word      string
+  inword    bool
 }
 
 /**
  * Create a scanner
  */
 func NewScanner(inpath, source string) *Scanner {
-  return &Scanner{inpath, source, len(source), 0, 0, 0, 0}
+  return NewScannerWithOptions(inpath, source, ScannerOptionNone)
+}
+
+/**
+ * Create a scanner with options
+ */
+func NewScannerWithOptions(inpath, source string, options int) *Scanner {
+  return &Scanner{inpath:inpath, source:source, length:len(source), options:options}
 }
 
 /**
@@ -247,6 +311,30 @@ func (s *Scanner) errorf(format string, args ...interface{}) *SourceError {
   return NewSourceError(s.inpath, s.source, s.index, s.line, s.column, format, args...)
 }
 
+/**
+ * Create a source error at the beginning of the most recently scanned directive.
+ * This is useful for reporting a problem with the directive itself, such as an
+ * import that cannot be resolved.
+ */
+func (s *Scanner) DirectiveErrorf(format string, args ...interface{}) *SourceError {
+  return NewSourceError(s.inpath, s.source, s.dindex, s.dline, s.dcolumn, format, args...)
+}
+
+/**
+ * Obtain the zero-based line on which the most recently scanned directive begins
+ */
+func (s *Scanner) DirectiveLine() int {
+  return s.dline
+}
+
+/**
+ * Obtain the byte range of the source occupied by the most recently scanned
+ * directive. This is only meaningful immediately after a directive is produced.
+ */
+func (s *Scanner) DirectiveRange() (int, int) {
+  return s.dindex, s.index
+}
+
 /**
  * Product a token
  */
@@ -260,30 +348,167 @@ func (s *Scanner) Token() ([]Token, error) {
       
       case eof:
         if s.index - start > 0 {
-          return []Token{ Token{TokenTypeVerbatim, s.source[start:s.index]}, Token{TokenTypeEOF, "EOF"} }, nil
+          return []Token{ Token{Type:TokenTypeVerbatim, Text:s.source[start:s.index]}, Token{Type:TokenTypeEOF, Text:"EOF"} }, nil
         }else{
-          return []Token{ Token{TokenTypeEOF, "EOF"} }, nil
+          return []Token{ Token{Type:TokenTypeEOF, Text:"EOF"} }, nil
         }
         
       case delimiter:
-        if p == 0 || p == '\n' {
+        if (p == 0 || p == '\n') && (s.lex.context == lexCode || (s.options & ScannerOptionLegacy) == ScannerOptionLegacy) {
           n := s.index - 1
+          s.dindex, s.dline, s.dcolumn = n, s.line, s.column - 1
           if t, err := s.directiveToken(); err != nil {
             return nil, err
           }else if n - start > 0 {
-            return append([]Token{ Token{TokenTypeVerbatim, s.source[start:n]} }, t...), nil
+            return append([]Token{ Token{Type:TokenTypeVerbatim, Text:s.source[start:n]} }, t...), nil
           }else{
             return t, nil
           }
         }
         
     }
+    if (s.options & ScannerOptionLegacy) != ScannerOptionLegacy {
+      s.lexRune(r)
+    }
     p = r
   }
   
   return nil, fmt.Errorf("Unexpected end of input")
 }
 
+/**
+ * Update the lexical context for a rune which has just been consumed
+ */
+func (s *Scanner) lexRune(r rune) {
+  l := &s.lex
+  if l.skip > 0 {
+    l.skip--
+    return
+  }
+  
+  switch l.context {
+    
+    case lexCode:
+      switch r {
+        case '/':
+          if n, _ := s.peek(); n == '/' {
+            l.context, l.skip = lexLineComment, 1
+          }else if n == '*' {
+            l.context, l.skip = lexBlockComment, 1
+          }else if l.regexAllowed() {
+            l.context, l.class = lexRegex, false
+          }else{
+            l.significant(r)
+          }
+        case '\'', '"':
+          l.context, l.quote = lexString, r
+        case '`':
+          l.context = lexTemplate
+        case '{':
+          if n := len(l.braces); n > 0 {
+            l.braces[n-1]++
+          }
+          l.significant(r)
+        case '}':
+          if n := len(l.braces); n > 0 {
+            if l.braces[n-1] == 0 {
+              l.braces, l.context = l.braces[:n-1], lexTemplate // end of a template substitution
+              return
+            }
+            l.braces[n-1]--
+          }
+          l.significant(r)
+        default:
+          l.significant(r)
+      }
+      
+    case lexLineComment:
+      if r == '\n' {
+        l.context = lexCode
+      }
+      
+    case lexBlockComment:
+      if n, _ := s.peek(); r == '*' && n == '/' {
+        l.context, l.skip = lexCode, 1
+      }
+      
+    case lexString:
+      if r == '\\' {
+        l.skip = 1
+      }else if r == l.quote || r == '\n' {
+        l.context = lexCode
+        l.value()
+      }
+      
+    case lexTemplate:
+      if n, _ := s.peek(); r == '\\' {
+        l.skip = 1
+      }else if r == '`' {
+        l.context = lexCode
+        l.value()
+      }else if r == '$' && n == '{' {
+        l.braces, l.context, l.skip = append(l.braces, 0), lexCode, 1
+        l.significant('{')
+      }
+      
+    case lexRegex:
+      if r == '\\' {
+        l.skip = 1
+      }else if r == '[' {
+        l.class = true
+      }else if r == ']' {
+        l.class = false
+      }else if r == '\n' || (r == '/' && !l.class) {
+        l.context = lexCode
+        l.value()
+      }
+      
+  }
+}
+
+/**
+ * Note a rune in code which is not part of a comment or literal
+ */
+func (l *lexer) significant(r rune) {
+  if isIdentifierRune(r) {
+    if !l.inword {
+      l.word, l.inword = "", true
+    }
+    l.word += string(r)
+  }else{
+    l.inword = false
+  }
+  if r > ' ' {
+    l.last = r
+  }
+}
+
+/**
+ * Note that a literal value has just ended
+ */
+func (l *lexer) value() {
+  l.last, l.inword = ')', false
+}
+
+/**
+ * Determine whether a '/' in code begins a regular expression. This is the case
+ * when an expression is expected, which we judge by what precedes it.
+ */
+func (l *lexer) regexAllowed() bool {
+  if isIdentifierRune(l.last) {
+    return regexKeywords[l.word]
+  }else{
+    return l.last == 0 || strings.ContainsRune("(,=:[!&|?{};+-*%<>~^", l.last)
+  }
+}
+
+/**
+ * Determine whether a rune can be part of an identifier or number
+ */
+func isIdentifierRune(r rune) bool {
+  return (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '$'
+}
+
 /**
  * Produce a directive token
  */
@@ -310,10 +535,34 @@ func (s *Scanner) directiveToken() ([]Token, error) {
 func (s *Scanner) importToken() ([]Token, error) {
   s.skipWhite()
   if resource, err := s.scanQuotedString(); err != nil {
+    if e, ok := err.(*SourceError); ok {
+      return nil, s.errorf("Expected quoted string: %s", e.Message())
+    }
     return nil, s.errorf("Expected quoted string: %v", err)
   }else{
-    return []Token{ Token{TokenTypeImport, resource} }, nil
+    return []Token{ Token{Type:TokenTypeImport, Text:resource, Integrity:s.scanIntegrity()} }, nil
+  }
+}
+
+/**
+ * Scan an optional integrity, like 'sha256-<base64 digest>', which may follow an
+ * import on the same line. Anything else on the line is left alone.
+ */
+func (s *Scanner) scanIntegrity() string {
+  i := s.index
+  for i < s.length && (s.source[i] == ' ' || s.source[i] == '\t') {
+    i++
+  }
+  
+  word := s.source[i:i+strings.IndexAny(s.source[i:]+"\n", " \t\r\n")]
+  if !strings.HasPrefix(word, "sha") || !strings.Contains(word, "-") {
+    return "" // not an integrity; leave it alone
+  }
+  
+  for s.index < i + len(word) {
+    s.next()
   }
+  return word
 }
 
 /**
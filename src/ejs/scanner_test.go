@@ -0,0 +1,196 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package ejs
+
+import (
+  "reflect"
+  "testing"
+)
+
+/**
+ * Scan a source and collect the resources it imports
+ */
+func scanImports(t *testing.T, source string, options int) []string {
+  imports := make([]string, 0)
+  scanner := NewScannerWithOptions("test.ejs", source, options)
+  for {
+    toks, err := scanner.Token()
+    if err != nil {
+      t.Fatalf("Could not scan: %v", err)
+    }
+    for _, e := range toks {
+      switch e.Type {
+        case TokenTypeEOF:
+          return imports
+        case TokenTypeImport:
+          imports = append(imports, e.Text)
+      }
+    }
+  }
+}
+
+/**
+ * Directives are only recognized in code, unless the legacy option is used
+ */
+func TestScannerImportContexts(t *testing.T) {
+  tests := []struct {
+    name      string
+    source    string
+    options   int
+    imports   []string
+  }{
+    {
+      "code",
+      "#import \"a.js\"\nvar a = 1;\n#import \"b.js\"\n",
+      ScannerOptionNone,
+      []string{"a.js", "b.js"},
+    },
+    {
+      "line comment",
+      "// a comment\n#import \"a.js\"\n",
+      ScannerOptionNone,
+      []string{"a.js"},
+    },
+    {
+      "block comment",
+      "/*\n#import \"a.js\"\n*/\n#import \"b.js\"\n",
+      ScannerOptionNone,
+      []string{"b.js"},
+    },
+    {
+      "block comment, single line",
+      "/* a comment */\n#import \"a.js\"\n",
+      ScannerOptionNone,
+      []string{"a.js"},
+    },
+    {
+      "string continuation",
+      "var s = \"a\\\n#import \\\"a.js\\\"\";\n#import \"b.js\"\n",
+      ScannerOptionNone,
+      []string{"b.js"},
+    },
+    {
+      "single quoted string with comment delimiter",
+      "var s = '/*';\n#import \"a.js\"\n",
+      ScannerOptionNone,
+      []string{"a.js"},
+    },
+    {
+      "template",
+      "var s = `\n#import \"a.js\"\n`;\n#import \"b.js\"\n",
+      ScannerOptionNone,
+      []string{"b.js"},
+    },
+    {
+      "template substitution",
+      "var s = `${ f({a: 1}) }\n#import \"a.js\"\n`;\n#import \"b.js\"\n",
+      ScannerOptionNone,
+      []string{"b.js"},
+    },
+    {
+      "nested template",
+      "var s = `${ `${ {a: `\n#import \"a.js\"\n`} }` }`;\n#import \"b.js\"\n",
+      ScannerOptionNone,
+      []string{"b.js"},
+    },
+    {
+      "code in template substitution",
+      "var s = `${\n0\n}`;\n#import \"a.js\"\n",
+      ScannerOptionNone,
+      []string{"a.js"},
+    },
+    {
+      "regex with comment delimiter",
+      "var r = /a\\/*b/;\n#import \"a.js\"\n",
+      ScannerOptionNone,
+      []string{"a.js"},
+    },
+    {
+      "regex with delimiter in class",
+      "var r = /[/*]/g;\n#import \"a.js\"\n",
+      ScannerOptionNone,
+      []string{"a.js"},
+    },
+    {
+      "regex with quote",
+      "if (/'/.test(s)) {}\n#import \"a.js\"\n",
+      ScannerOptionNone,
+      []string{"a.js"},
+    },
+    {
+      "regex after keyword",
+      "function f(s) { return /`/.test(s) }\n#import \"a.js\"\n",
+      ScannerOptionNone,
+      []string{"a.js"},
+    },
+    {
+      "division",
+      "var a = b / 2, c = '/';\n#import \"a.js\"\n",
+      ScannerOptionNone,
+      []string{"a.js"},
+    },
+    {
+      "legacy, block comment",
+      "/*\n#import \"a.js\"\n*/\n#import \"b.js\"\n",
+      ScannerOptionLegacy,
+      []string{"a.js", "b.js"},
+    },
+    {
+      "legacy, template",
+      "var s = `\n#import \"a.js\"\n`;\n",
+      ScannerOptionLegacy,
+      []string{"a.js"},
+    },
+    {
+      "legacy, unterminated string",
+      "var s = 'a\n#import \"a.js\"\n",
+      ScannerOptionLegacy,
+      []string{"a.js"},
+    },
+  }
+  
+  for _, e := range tests {
+    t.Run(e.name, func(t *testing.T) {
+      if imports := scanImports(t, e.source, e.options); !reflect.DeepEqual(imports, e.imports) {
+        t.Errorf("Expected imports %q, got %q", e.imports, imports)
+      }
+    })
+  }
+}
+
+/**
+ * Directives must begin at the start of a line
+ */
+func TestScannerImportLineStart(t *testing.T) {
+  if imports := scanImports(t, "var a = 1; #import \"a.js\"\n", ScannerOptionNone); len(imports) > 0 {
+    t.Errorf("Expected no imports, got %q", imports)
+  }
+}
@@ -39,29 +39,64 @@ import (
 const (
   compilerOptionNone        = 0
   compilerOptionVerbose     = 1 << 0
+  compilerOptionNoMinify    = 1 << 1
 )
 
+/**
+ * The number of bytes an imported resource contributed to its bundle, not
+ * including the resources it imported itself
+ */
+type ImportSize struct {
+  File        string    `json:"file"`
+  Size        int64     `json:"size"`
+}
+
 /**
  * Compilation context
  */
 type Context struct {
-  Options   int
-  Variables map[string]interface{}
-  visited   map[string]bool
+  Options     int
+  Variables   map[string]interface{}
+  config      *Options
+  visited     map[string]bool
+  stack       []string
+  diagnostics []*Diagnostic
+  imports     []ImportSize
 }
 
 /**
  * Create a compiler context
  */
 func NewContext() *Context {
-  return NewContextWithVariables(SharedOptions().Variables)
+  return NewContextWithOptions(SharedOptions())
+}
+
+/**
+ * Create a compiler context which compiles with the provided options, rather than
+ * the shared options
+ */
+func NewContextWithOptions(options *Options) *Context {
+  context := NewContextWithVariables(options.Variables)
+  context.config = options
+  return context
 }
 
 /**
  * Create a compiler context
  */
 func NewContextWithVariables(v map[string]interface{}) *Context {
-  return &Context{0, v, make(map[string]bool)}
+  return &Context{Options: 0, Variables: v, visited: make(map[string]bool)}
+}
+
+/**
+ * Obtain the options we compile with
+ */
+func (c *Context) Config() *Options {
+  if c.config != nil {
+    return c.config
+  }else{
+    return SharedOptions()
+  }
 }
 
 /**
@@ -91,6 +126,108 @@ func (c *Context) ClearVisited() {
   }
 }
 
+/**
+ * Note that a resource is being compiled. Resources which are being compiled
+ * form the import stack, which is distinct from the set of visited resources:
+ * a resource is only on the stack until it has been compiled.
+ */
+func (c *Context) PushResource(resource string) {
+  c.stack = append(c.stack, resource)
+}
+
+/**
+ * Note that the most recently pushed resource has been compiled
+ */
+func (c *Context) PopResource() {
+  if l := len(c.stack); l > 0 {
+    c.stack = c.stack[:l-1]
+  }
+}
+
+/**
+ * If importing the provided resource would create a cycle, obtain the chain of
+ * imports which forms it, beginning and ending with that resource. Otherwise nil
+ * is returned.
+ */
+func (c *Context) ImportCycle(resource string) []string {
+  for i, e := range c.stack {
+    if e == resource {
+      return append(append([]string{}, c.stack[i:]...), resource)
+    }
+  }
+  return nil
+}
+
+/**
+ * Record the size an imported resource contributed to the resource being compiled
+ */
+func (c *Context) RecordImport(file string, size int64) {
+  c.imports = append(c.imports, ImportSize{file, size})
+}
+
+/**
+ * Obtain the sizes of every resource imported during compilation
+ */
+func (c *Context) Imports() []ImportSize {
+  return c.imports
+}
+
+/**
+ * Obtain the total size of every resource imported so far
+ */
+func (c *Context) importedSize() int64 {
+  var n int64
+  for _, e := range c.imports {
+    n += e.Size
+  }
+  return n
+}
+
+/**
+ * Report a problem which does not prevent compilation from continuing. Compilers
+ * report errors this way when they can recover from them so that every problem
+ * with a resource can be reported at once.
+ */
+func (c *Context) Report(severity, file string, err error) *Diagnostic {
+  d := NewDiagnostic(severity, file, err)
+  c.diagnostics = append(c.diagnostics, d)
+  return d
+}
+
+/**
+ * Obtain the problems reported during compilation
+ */
+func (c *Context) Diagnostics() []*Diagnostic {
+  return c.diagnostics
+}
+
+/**
+ * Determine whether any errors have been reported
+ */
+func (c *Context) HasErrors() bool {
+  for _, e := range c.diagnostics {
+    if e.Severity == SeverityError {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Obtain the failure for a compilation, which includes every reported problem
+ * along with the error that stopped compilation, if any. If compilation did not
+ * fail nil is returned.
+ */
+func (c *Context) Failure(err error) error {
+  if len(c.diagnostics) < 1 || (err == nil && !c.HasErrors()) {
+    return err
+  }else if err != nil {
+    return Diagnostics(append(c.diagnostics, NewDiagnostic(SeverityError, "", err)))
+  }else{
+    return Diagnostics(c.diagnostics)
+  }
+}
+
 /**
  * A compiler
  */
@@ -115,31 +252,32 @@ func CanCompile(context *Context, inpath string) bool {
  * Create the default compiler for the specified file
  */
 func NewCompiler(context *Context, inpath string) (Compiler, error) {
+  minify := context.Options & compilerOptionNoMinify == 0
   switch path.Ext(inpath) {
     
     case ".scss":
-      if SharedOptions().Stylesheet.Minify {
+      if minify && context.Config().Stylesheet.Minify {
         return &SassCompiler{sassOptionCompress}, nil
       }else{
         return &SassCompiler{}, nil
       }
       
     case ".css":
-      if SharedOptions().Stylesheet.Minify {
+      if minify && context.Config().Stylesheet.Minify {
         return &SassCompiler{sassOptionCompress}, nil
       }else{
         return &LiteralCompiler{}, nil
       }
       
     case ".ejs":
-      if SharedOptions().Javascript.Minify {
+      if minify && context.Config().Javascript.Minify {
         return CompilerChain([]Compiler{ &EJSCompiler{}, &JSMinCompiler{} }), nil
       }else{
         return &EJSCompiler{}, nil
       }
       
     case ".js":
-      if SharedOptions().Javascript.Minify {
+      if minify && context.Config().Javascript.Minify {
         return &JSMinCompiler{}, nil
       }else{
         return &LiteralCompiler{}, nil
@@ -36,16 +36,13 @@ import (
   "fmt"
 	"path"
   "regexp"
+  "strings"
   "io/ioutil"
   "path/filepath"
 )
 
 import "ejs"
 
-import (
-  "net/http"
-)
-
 /**
  * An "extended Javascript" (EJS) compiler
  */
@@ -77,7 +74,15 @@ func (c EJSCompiler) Compile(context *Context, inpath, outpath string, input io.
     return err
   }
   
-  scanner := ejs.NewScanner(inpath, string(source))
+  // we're on the import stack until we've been compiled
+  if abs, err := filepath.Abs(inpath); err != nil {
+    return err
+  }else{
+    context.PushResource(abs)
+    defer context.PopResource()
+  }
+  
+  scanner := newEJSScanner(context.Config(), inpath, string(source))
   outer:
   for {
     
@@ -98,7 +103,7 @@ func (c EJSCompiler) Compile(context *Context, inpath, outpath string, input io.
           }
         
         case ejs.TokenTypeImport:
-          if err := c.emitImport(context, inpath, outpath, output, tok.Text); err != nil {
+          if err := c.emitImport(context, scanner, inpath, outpath, output, tok.Text, tok.Integrity); err != nil {
             return err
           }
         
@@ -112,9 +117,21 @@ func (c EJSCompiler) Compile(context *Context, inpath, outpath string, input io.
 }
 
 /**
- * Emit an import
+ * Create a scanner for EJS source
+ */
+func newEJSScanner(options *Options, inpath, source string) *ejs.Scanner {
+  if options.Javascript.LegacyDirectives {
+    return ejs.NewScannerWithOptions(inpath, source, ejs.ScannerOptionLegacy)
+  }else{
+    return ejs.NewScanner(inpath, source)
+  }
+}
+
+/**
+ * Emit an import. Imports which cannot be resolved are reported and compilation
+ * continues so that every problem can be reported at once.
  */
-func (c EJSCompiler) emitImport(context *Context, inpath, outpath string, output io.Writer, resource string) error {
+func (c EJSCompiler) emitImport(context *Context, scanner *ejs.Scanner, inpath, outpath string, output io.Writer, resource, integrity string) error {
   var absolute string
   
   isurl, err := regexp.MatchString("^https?://", resource)
@@ -128,7 +145,19 @@ func (c EJSCompiler) emitImport(context *Context, inpath, outpath string, output
     absolute = abs
   }
   
-  if context.IsVisited(absolute) {
+  if cycle := context.ImportCycle(absolute); cycle != nil {
+    chain := make([]string, len(cycle))
+    for i, e := range cycle {
+      chain[i] = displayPath(e)
+    }
+    severity := SeverityError
+    if context.Config().Javascript.CircularImports == SeverityWarning {
+      severity = SeverityWarning
+    }
+    context.Report(severity, inpath, scanner.DirectiveErrorf("Circular import: %s", strings.Join(chain, " \u2192 "))).Code = DiagnosticCodeCircularImport
+    return nil
+  }else if context.IsVisited(absolute) {
+    context.Report(SeverityWarning, inpath, scanner.DirectiveErrorf("Skipping duplicate import: %s", resource)).Code = DiagnosticCodeDuplicateImport
     return nil
   }else{
     context.AddVisited(absolute)
@@ -138,27 +167,39 @@ func (c EJSCompiler) emitImport(context *Context, inpath, outpath string, output
     return err
   }
   
+  // count what this import contributes, less whatever it imports itself
+  counter := &countingWriter{Writer: output}
+  nested := context.importedSize()
+  
   if isurl {
-    return c.emitImportURL(context, inpath, outpath, output, resource)
+    err = c.emitImportURL(context, inpath, outpath, counter, resource, integrity)
   }else{
-    return c.emitImportFile(context, inpath, outpath, output, absolute)
+    if integrity != "" {
+      context.Report(SeverityWarning, inpath, scanner.DirectiveErrorf("Integrity is only checked for remote imports: %s", resource))
+    }
+    err = c.emitImportFile(context, inpath, outpath, counter, absolute)
+  }
+  
+  context.RecordImport(absolute, counter.count - (context.importedSize() - nested))
+  
+  if err != nil {
+    context.Report(SeverityError, inpath, scanner.DirectiveErrorf("Could not import %s: %v", resource, err)).Code = DiagnosticCodeImport
   }
   
+  return nil
 }
 
 /**
  * Emit an import
  */
-func (c EJSCompiler) emitImportURL(context *Context, inpath, outpath string, output io.Writer, resource string) error {
+func (c EJSCompiler) emitImportURL(context *Context, inpath, outpath string, output io.Writer, resource, integrity string) error {
   
-  resp, err := http.Get(resource)
+  data, err := sharedRemoteImports(context.Config().Imports).fetch(resource, integrity)
   if err != nil {
     return err
-  }else{
-    defer resp.Body.Close()
   }
   
-  if _, err := io.Copy(output, resp.Body); err != nil {
+  if _, err := output.Write(data); err != nil {
     return err
   }
   
@@ -171,12 +212,33 @@ func (c EJSCompiler) emitImportURL(context *Context, inpath, outpath string, out
 func (c EJSCompiler) emitImportFile(context *Context, inpath, outpath string, output io.Writer, resource string) error {
   
   if file, err := os.Open(resource); err != nil {
-    return fmt.Errorf("Could not import file (via %s): %s", inpath, err)
+    return err
   }else if compiler, err := NewCompiler(context, file.Name()); err != nil {
+    file.Close()
     return err
   }else{
-    return compiler.Compile(context, file.Name(), "", file, output);
+    defer file.Close()
+    if err := compiler.Compile(context, file.Name(), "", file, output); err != nil {
+      context.Report(SeverityError, file.Name(), err) // a problem in the imported file itself
+    }
+    return nil
   }
   
 }
 
+/**
+ * A writer which counts the bytes written through it
+ */
+type countingWriter struct {
+  io.Writer
+  count   int64
+}
+
+/**
+ * Write data
+ */
+func (w *countingWriter) Write(p []byte) (int, error) {
+  n, err := w.Writer.Write(p)
+  w.count += int64(n)
+  return n, err
+}
@@ -0,0 +1,256 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+package main
+
+import (
+  "os"
+  "fmt"
+  "sync"
+  "time"
+  "reflect"
+  "strings"
+  "io/ioutil"
+  "path/filepath"
+)
+
+import (
+  "net/http"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "encoding/base64"
+)
+
+const (
+  IMPORT_CACHE_PATH_DEFAULT = "./.slang/cache/imports"
+  IMPORT_LOCKFILE_DEFAULT   = "./slang.lock"
+  IMPORT_TIMEOUT_DEFAULT    = 30 * time.Second
+  IMPORT_INTEGRITY_PREFIX   = "sha256-"
+)
+
+/**
+ * The remote import store shared by compilers
+ */
+var __remoteImports *remoteImports
+var __remoteImportsLock sync.Mutex
+
+/**
+ * The lockfile, which records the integrity of every remote import
+ */
+type importLockfile struct {
+  Imports   map[string]string     `json:"imports"`
+}
+
+/**
+ * Remote imports. Imports are fetched once and cached on disk, keyed by URL. The
+ * integrity of each import is recorded in the lockfile the first time it is
+ * fetched and every later use of the import must match it, so an import can't
+ * change out from under us. An integrity declared on the import itself takes
+ * precedence and updates the lockfile.
+ */
+type remoteImports struct {
+  sync.Mutex
+  options   ImportOptions
+  client    *http.Client
+  lockfile  *importLockfile
+}
+
+/**
+ * Obtain the remote import store for the provided options
+ */
+func sharedRemoteImports(options ImportOptions) *remoteImports {
+  __remoteImportsLock.Lock()
+  defer __remoteImportsLock.Unlock()
+  if __remoteImports == nil || !reflect.DeepEqual(__remoteImports.options, options) {
+    __remoteImports = newRemoteImports(options)
+  }
+  return __remoteImports
+}
+
+/**
+ * Create a remote import store
+ */
+func newRemoteImports(options ImportOptions) *remoteImports {
+  if options.CacheRoot == "" {
+    options.CacheRoot = IMPORT_CACHE_PATH_DEFAULT
+  }
+  if options.Lockfile == "" {
+    options.Lockfile = IMPORT_LOCKFILE_DEFAULT
+  }
+  if options.Timeout == 0 {
+    options.Timeout = IMPORT_TIMEOUT_DEFAULT
+  }
+  return &remoteImports{options: options, client: &http.Client{Timeout: options.Timeout}}
+}
+
+/**
+ * Obtain the content of a remote import. If an integrity is provided the content
+ * must match it.
+ */
+func (r *remoteImports) fetch(url, integrity string) ([]byte, error) {
+  if integrity != "" && !strings.HasPrefix(integrity, IMPORT_INTEGRITY_PREFIX) {
+    return nil, fmt.Errorf("Integrity is not supported, only '%s' is: %s", IMPORT_INTEGRITY_PREFIX, integrity)
+  }
+  
+  expect := integrity
+  if expect == "" {
+    pinned, err := r.pinned(url)
+    if err != nil {
+      return nil, err
+    }
+    expect = pinned
+  }
+  
+  // a cached import that doesn't match is stale and is fetched again
+  data, err := ioutil.ReadFile(r.path(url))
+  cached := err == nil && (expect == "" || importIntegrity(data) == expect)
+  if err != nil && !os.IsNotExist(err) {
+    return nil, err
+  }
+  
+  if !cached {
+    if r.options.Offline {
+      return nil, fmt.Errorf("Import is not cached and we are offline: %s", url)
+    }
+    if data, err = r.download(url); err != nil {
+      return nil, err
+    }
+  }
+  
+  actual := importIntegrity(data)
+  if expect != "" && actual != expect {
+    return nil, fmt.Errorf("Integrity check failed: %s: expected %s but got %s", url, expect, actual)
+  }
+  
+  if err := r.pin(url, actual); err != nil {
+    return nil, err
+  }
+  if !cached {
+    if err := os.MkdirAll(r.options.CacheRoot, 0755); err != nil {
+      return nil, err
+    }else if err := writeOutput(r.path(url), data); err != nil {
+      return nil, err
+    }
+  }
+  
+  return data, nil
+}
+
+/**
+ * Download a remote import
+ */
+func (r *remoteImports) download(url string) ([]byte, error) {
+  rsp, err := r.client.Get(url)
+  if err != nil {
+    return nil, err
+  }else{
+    defer rsp.Body.Close()
+  }
+  
+  if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+    return nil, fmt.Errorf("Could not fetch import: %s: %s", url, rsp.Status)
+  }
+  
+  return ioutil.ReadAll(rsp.Body)
+}
+
+/**
+ * The path at which an import is cached
+ */
+func (r *remoteImports) path(url string) string {
+  sum := sha256.Sum256([]byte(url))
+  return filepath.Join(r.options.CacheRoot, hex.EncodeToString(sum[:]))
+}
+
+/**
+ * Obtain the integrity recorded in the lockfile for an import, if any
+ */
+func (r *remoteImports) pinned(url string) (string, error) {
+  r.Lock()
+  defer r.Unlock()
+  if err := r.loadLockfile(); err != nil {
+    return "", err
+  }
+  return r.lockfile.Imports[url], nil
+}
+
+/**
+ * Record the integrity of an import in the lockfile
+ */
+func (r *remoteImports) pin(url, integrity string) error {
+  r.Lock()
+  defer r.Unlock()
+  
+  if err := r.loadLockfile(); err != nil {
+    return err
+  }else if r.lockfile.Imports[url] == integrity {
+    return nil
+  }
+  
+  r.lockfile.Imports[url] = integrity
+  data, err := json.MarshalIndent(r.lockfile, "", "  ")
+  if err != nil {
+    return err
+  }
+  
+  return writeOutput(r.options.Lockfile, append(data, '\n'))
+}
+
+/**
+ * Load the lockfile, if we haven't already
+ */
+func (r *remoteImports) loadLockfile() error {
+  if r.lockfile != nil {
+    return nil
+  }
+  
+  lockfile := &importLockfile{}
+  if data, err := ioutil.ReadFile(r.options.Lockfile); err != nil && !os.IsNotExist(err) {
+    return err
+  }else if err == nil {
+    if err := json.Unmarshal(data, lockfile); err != nil {
+      return fmt.Errorf("Lockfile is not valid: %s: %v", r.options.Lockfile, err)
+    }
+  }
+  if lockfile.Imports == nil {
+    lockfile.Imports = make(map[string]string)
+  }
+  
+  r.lockfile = lockfile
+  return nil
+}
+
+/**
+ * Compute the integrity of content, as 'sha256-<base64 digest>'
+ */
+func importIntegrity(data []byte) string {
+  sum := sha256.Sum256(data)
+  return IMPORT_INTEGRITY_PREFIX + base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,291 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "os"
+  "fmt"
+  "strings"
+  "encoding/json"
+)
+
+import (
+  "ejs"
+  "bww/errors"
+)
+
+/**
+ * Diagnostic severities
+ */
+const (
+  SeverityError   = "error"
+  SeverityWarning = "warning"
+)
+
+/**
+ * Diagnostic codes
+ */
+const (
+  DiagnosticCodeSyntax    = "syntax"
+  DiagnosticCodeNotFound  = "not-found"
+  DiagnosticCodeIO        = "io"
+  DiagnosticCodeImport    = "import"
+  DiagnosticCodeDuplicateImport = "duplicate-import"
+  DiagnosticCodeCircularImport = "circular-import"
+  DiagnosticCodeBudget    = "budget"
+  DiagnosticCodeUnused    = "unused"
+  DiagnosticCodeDuplicated = "duplicated"
+  DiagnosticCodeModified  = "modified"
+)
+
+/**
+ * Diagnostic output formats
+ */
+const (
+  DIAGNOSTIC_FORMAT_TEXT  = "text"
+  DIAGNOSTIC_FORMAT_JSON  = "json"
+)
+
+/**
+ * A diagnostic describes a problem with a resource. Lines and columns are
+ * one-based; zero means unknown.
+ */
+type Diagnostic struct {
+  Severity  string        `json:"severity"`
+  Code      string        `json:"code,omitempty"`
+  File      string        `json:"file,omitempty"`
+  Line      int           `json:"line,omitempty"`
+  Column    int           `json:"column,omitempty"`
+  Message   string        `json:"message"`
+  Excerpt   []string      `json:"excerpt,omitempty"`
+  Cause     *Diagnostic   `json:"cause,omitempty"`
+  err       error
+}
+
+/**
+ * A set of diagnostics, which together describe a failure
+ */
+type Diagnostics []*Diagnostic
+
+/**
+ * Create a diagnostic from an error. The cause chain of the error is preserved
+ * and the most specific location and code found in the chain are reported
+ * for the diagnostic itself. If the error doesn't identify a file the provided
+ * file is used.
+ */
+func NewDiagnostic(severity, file string, err error) *Diagnostic {
+  if d, ok := err.(*Diagnostic); ok {
+    return d
+  }
+  
+  d := &Diagnostic{Severity:severity, File:file, err:err}
+  
+  switch e := err.(type) {
+    case *errors.Error:
+      d.Message = e.Message()
+      if c := e.Cause(); c != nil {
+        d.Cause = NewDiagnostic(severity, file, c)
+      }
+    case *ejs.SourceError:
+      d.Code = DiagnosticCodeSyntax
+      d.File = e.Path()
+      d.Line = e.Line() + 1
+      d.Column = e.Column() + 1
+      d.Message = e.Message()
+      d.Excerpt = e.ExcerptLines("", "", nil, 3)
+    case *os.PathError:
+      if os.IsNotExist(e) {
+        d.Code = DiagnosticCodeNotFound
+      }else{
+        d.Code = DiagnosticCodeIO
+      }
+      d.File = e.Path
+      d.Message = e.Error()
+    default:
+      d.Message = err.Error()
+  }
+  
+  if c := d.Cause; c != nil {
+    if d.Line == 0 && c.Line > 0 {
+      d.File, d.Line, d.Column, d.Excerpt = c.File, c.Line, c.Column, c.Excerpt
+    }
+    if d.Code == "" {
+      d.Code = c.Code
+    }
+  }
+  
+  return d
+}
+
+/**
+ * Obtain the diagnostic location, formatted as 'file:line:column'
+ */
+func (d *Diagnostic) Location() string {
+  l := d.File
+  if d.Line > 0 {
+    l += fmt.Sprintf(":%d", d.Line)
+    if d.Column > 0 {
+      l += fmt.Sprintf(":%d", d.Column)
+    }
+  }
+  return l
+}
+
+/**
+ * Obtain the full message, including every cause
+ */
+func (d *Diagnostic) FullMessage() string {
+  m := d.Message
+  for c := d.Cause; c != nil; c = c.Cause {
+    m += ": "+ c.Message
+  }
+  return m
+}
+
+/**
+ * Format the diagnostic as a single line: 'file:line:column: severity: message'
+ */
+func (d *Diagnostic) String() string {
+  if l := d.Location(); l != "" {
+    return fmt.Sprintf("%s: %s: %s", l, d.Severity, d.FullMessage())
+  }else{
+    return fmt.Sprintf("%s: %s", d.Severity, d.FullMessage())
+  }
+}
+
+/**
+ * A diagnostic is an error
+ */
+func (d *Diagnostic) Error() string {
+  return d.String()
+}
+
+/**
+ * Diagnostics are an error
+ */
+func (d Diagnostics) Error() string {
+  s := make([]string, len(d))
+  for i, e := range d {
+    s[i] = e.String()
+  }
+  return strings.Join(s, "\n")
+}
+
+/**
+ * Count the diagnostics of the specified severity
+ */
+func (d Diagnostics) Count(severity string) int {
+  n := 0
+  for _, e := range d {
+    if e.Severity == severity {
+      n++
+    }
+  }
+  return n
+}
+
+/**
+ * Summarize the diagnostics, e.g., '2 errors, 1 warning'
+ */
+func (d Diagnostics) Summary() string {
+  return d.summarize(d.Count(SeverityError), d.Count(SeverityWarning))
+}
+
+/**
+ * Summarize counts of errors and warnings
+ */
+func (d Diagnostics) summarize(errors, warnings int) string {
+  plural := func(n int, noun string) string {
+    if n == 1 {
+      return fmt.Sprintf("%d %s", n, noun)
+    }else{
+      return fmt.Sprintf("%d %ss", n, noun)
+    }
+  }
+  return plural(errors, SeverityError) +", "+ plural(warnings, SeverityWarning)
+}
+
+/**
+ * Determine the status a build with these diagnostics should exit with. I/O
+ * errors take precedence over compile errors.
+ */
+func (d Diagnostics) ExitStatus() int {
+  status := EXIT_SUCCESS
+  for _, e := range d {
+    if e.Severity != SeverityError {
+      continue
+    }else if e.Code == DiagnosticCodeIO || e.Code == DiagnosticCodeNotFound {
+      return EXIT_IO
+    }else{
+      status = EXIT_COMPILE
+    }
+  }
+  return status
+}
+
+/**
+ * Obtain the diagnostics which describe an error
+ */
+func diagnosticsForError(severity, file string, err error) Diagnostics {
+  if d, ok := err.(Diagnostics); ok {
+    return d
+  }else{
+    return Diagnostics{NewDiagnostic(severity, file, err)}
+  }
+}
+
+/**
+ * Print diagnostics in the specified format
+ */
+func printDiagnostics(format string, diagnostics Diagnostics, extra map[string]interface{}) {
+  switch format {
+    case DIAGNOSTIC_FORMAT_JSON:
+      if diagnostics == nil {
+        diagnostics = Diagnostics{}
+      }
+      doc := map[string]interface{}{"diagnostics": diagnostics}
+      for k, v := range extra {
+        doc[k] = v
+      }
+      if data, err := json.MarshalIndent(doc, "", "  "); err != nil {
+        fmt.Println(err)
+      }else{
+        fmt.Println(string(data))
+      }
+    default:
+      for _, e := range diagnostics {
+        fmt.Println(e.String())
+        for _, l := range e.Excerpt {
+          fmt.Println("    "+ strings.TrimRight(l, "\n"))
+        }
+      }
+  }
+}
@@ -56,3 +56,14 @@ func (a *AssocParams) Set(value string) error {
   }
 }
 
+
+type ListParams []string
+
+func (a *ListParams) String() string {
+  return fmt.Sprintf("%v", *a)
+}
+
+func (a *ListParams) Set(value string) error {
+  *a = append(*a, strings.Trim(value, " \n"))
+  return nil
+}
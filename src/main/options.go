@@ -37,6 +37,11 @@ import (
   "io/ioutil"
   "path"
   "path/filepath"
+  "time"
+  "sync"
+  "sort"
+  "strconv"
+  "strings"
   "reflect"
 )
 
@@ -60,18 +65,29 @@ const (
  * Shared options / global config
  */
 var __options *Options
+var __optionsLock sync.RWMutex
 
 /**
  * Options
  */
 type Options struct {
   home        string
+  config      string
   Flags       int
   Routes      map[string][]string
+  RouteRules  []RouteOptions
   Server      ServerOptions
+  Proxy       ProxyOptions
   Stylesheet  StylesheetOptions
   Javascript  JavascriptOptions
   Unmanaged   UnmanagedOptions
+  Build       BuildOptions
+  Imports     ImportOptions
+  Vendor      VendorOptions
+  Mocks       []MockOptions
+  Headers     []HeaderOptions
+  Budgets     []BudgetOptions
+  Network     NetworkOptions
   Variables   map[string]interface{}
 }
 
@@ -79,9 +95,85 @@ type Options struct {
  * Server options
  */
 type ServerOptions struct {
-  Port      int                   `toml:"port"`
-  Proxy     string                `toml:"proxy"`
-  Root      string                `toml:"root"`
+  Port          int                 `toml:"port"`
+  Proxy         string              `toml:"proxy"`
+  Root          string              `toml:"root"`
+  Listen        []string            `toml:"listen"`
+  HAR           string              `toml:"har"`
+  HARLimit      int                 `toml:"har_limit"`
+  HTTPS         bool                `toml:"https"`
+  Redirect      int                 `toml:"https_redirect_port"`
+  Hostnames     []string            `toml:"hostnames"`
+  Certificate   string              `toml:"certificate"`
+  Key           string              `toml:"key"`
+  Certificates  string              `toml:"certificates"`
+  ShutdownTimeout time.Duration     `toml:"shutdown_timeout"`
+  Fallback      string              `toml:"fallback"`
+  Listing       bool                `toml:"directory_listing"`
+  Compress      bool                `toml:"compress"`
+}
+
+/**
+ * Proxy options
+ */
+type ProxyOptions struct {
+  RewriteLocation bool            `toml:"rewrite_location"`
+  RewriteCookies  bool            `toml:"rewrite_cookies"`
+  CORS            bool            `toml:"cors"`
+  CORSOrigin      string          `toml:"cors_origin"`
+  Cache           string          `toml:"cache"`
+  CacheRoot       string          `toml:"cache_root"`
+  CacheHeaders    []string        `toml:"cache_headers"`
+}
+
+/**
+ * Route rule options
+ */
+type RouteOptions struct {
+  Match     string
+  Pattern   string
+  Targets   []string
+  Strict    bool
+  NoProxy   bool
+}
+
+/**
+ * Mock endpoint options
+ */
+type MockOptions struct {
+  Method    string
+  Path      string
+  File      string
+  Body      string
+  Status    int
+  Headers   map[string]string
+  Latency   time.Duration
+}
+
+/**
+ * Response header options
+ */
+type HeaderOptions struct {
+  Path      string
+  Values    map[string]string
+  Proxied   bool
+}
+
+/**
+ * Size budget options. A limit of zero is not enforced.
+ */
+type BudgetOptions struct {
+  Pattern   string
+  Size      int64
+  Gzip      int64
+}
+
+/**
+ * Network simulation options
+ */
+type NetworkOptions struct {
+  NetworkConditions
+  Routes    []NetworkRoute
 }
 
 /**
@@ -96,8 +188,10 @@ type StylesheetOptions struct {
  * Javascript options
  */
 type JavascriptOptions struct {
-  Minify    bool                  `toml:"minify"`
-  Exclude   []string              `toml:"exclude"`
+  Minify            bool          `toml:"minify"`
+  Exclude           []string      `toml:"exclude"`
+  CircularImports   string        `toml:"circular_imports"`
+  LegacyDirectives  bool          `toml:"legacy_directives"`
 }
 
 /**
@@ -108,6 +202,37 @@ type UnmanagedOptions struct {
   Exclude   []string              `toml:"exclude_from_copy"`
 }
 
+/**
+ * Build options
+ */
+type BuildOptions struct {
+  Compress          []string        `toml:"compress"`
+  CompressThreshold int64           `toml:"compress_threshold"`
+  Format            string          `toml:"-"`
+  KeepGoing         bool            `toml:"keep_going"`
+  Stage             bool            `toml:"stage"`
+  Report            bool            `toml:"report"`
+}
+
+/**
+ * Remote import options
+ */
+type ImportOptions struct {
+  CacheRoot         string          `toml:"cache_root"`
+  Lockfile          string          `toml:"lockfile"`
+  Timeout           time.Duration   `toml:"timeout"`
+  Offline           bool            `toml:"offline"`
+}
+
+/**
+ * Vendoring options
+ */
+type VendorOptions struct {
+  Path              string          `toml:"path"`
+  Rewrite           bool            `toml:"rewrite"`
+  Force             bool            `toml:"-"`
+}
+
 /**
  * Determine whether a file should be copied
  */
@@ -130,19 +255,112 @@ type config struct {
   Verbose     *bool                   `toml:"verbose"`
   Debug       *bool                   `toml:"debug"`
   Server      serverConfig            `toml:"server"`
+  Proxy       proxyConfig             `toml:"proxy"`
   Routes      map[string]interface{}  `toml:"routes"`
+  RouteRules  []routeConfig           `toml:"route"`
   Stylesheet  stylesheetConfig        `toml:"stylesheet"`
   Javascript  javascriptConfig        `toml:"javascript"`
   Unmanaged   unmanagedConfig         `toml:"unmanaged"`
+  Build       buildConfig             `toml:"build"`
+  Imports     importsConfig           `toml:"imports"`
+  Vendor      vendorConfig            `toml:"vendor"`
+  Mocks       []mockConfig            `toml:"mock"`
+  Headers     []headersConfig         `toml:"headers"`
+  Budgets     map[string]interface{}  `toml:"budgets"`
+  Network     networkConfig           `toml:"network"`
 }
 
 /**
  * Server config
  */
 type serverConfig struct {
-  Port      *int                      `toml:"port"`
-  Proxy     *string                   `toml:"proxy"`
-  Root      *string                   `toml:"root"`
+  Port          *int                `toml:"port"`
+  Proxy         *string             `toml:"proxy"`
+  Root          *string             `toml:"root"`
+  Listen        *[]string           `toml:"listen"`
+  HAR           *string             `toml:"har"`
+  HARLimit      *int                `toml:"har_limit"`
+  HTTPS         *bool               `toml:"https"`
+  Redirect      *int                `toml:"https_redirect_port"`
+  Hostnames     *[]string           `toml:"hostnames"`
+  Certificate   *string             `toml:"certificate"`
+  Key           *string             `toml:"key"`
+  Certificates  *string             `toml:"certificates"`
+  ShutdownTimeout *string           `toml:"shutdown_timeout"`
+  Fallback      *string             `toml:"fallback"`
+  Listing       *bool               `toml:"directory_listing"`
+  Compress      *bool               `toml:"compress"`
+}
+
+/**
+ * Proxy config
+ */
+type proxyConfig struct {
+  RewriteLocation *bool               `toml:"rewrite_location"`
+  RewriteCookies  *bool               `toml:"rewrite_cookies"`
+  CORS            *bool               `toml:"cors"`
+  CORSOrigin      *string             `toml:"cors_origin"`
+  Cache           *string             `toml:"cache"`
+  CacheRoot       *string             `toml:"cache_root"`
+  CacheHeaders    *[]string           `toml:"cache_headers"`
+}
+
+/**
+ * Route rule config
+ */
+type routeConfig struct {
+  Prefix    string                    `toml:"prefix"`
+  Glob      string                    `toml:"glob"`
+  Regex     string                    `toml:"regex"`
+  To        interface{}               `toml:"to"`
+  Strict    bool                      `toml:"strict"`
+  Proxy     *bool                     `toml:"proxy"`
+}
+
+/**
+ * Mock endpoint config
+ */
+type mockConfig struct {
+  Method    string                    `toml:"method"`
+  Path      string                    `toml:"path"`
+  File      string                    `toml:"file"`
+  Body      string                    `toml:"body"`
+  Status    int                       `toml:"status"`
+  Headers   map[string]string         `toml:"headers"`
+  Latency   string                    `toml:"latency"`
+}
+
+/**
+ * Response header config
+ */
+type headersConfig struct {
+  Path      string                    `toml:"path"`
+  Values    map[string]string         `toml:"values"`
+  Proxied   bool                      `toml:"proxied"`
+}
+
+/**
+ * Network simulation config
+ */
+type networkConfig struct {
+  Latency       string                `toml:"latency"`
+  Jitter        string                `toml:"jitter"`
+  Bandwidth     int                   `toml:"bandwidth"`
+  FailureRate   float64               `toml:"failure_rate"`
+  FailureStatus []int                 `toml:"failure_status"`
+  Routes        []networkRouteConfig  `toml:"route"`
+}
+
+/**
+ * Network simulation route config
+ */
+type networkRouteConfig struct {
+  Path          string                `toml:"path"`
+  Latency       string                `toml:"latency"`
+  Jitter        string                `toml:"jitter"`
+  Bandwidth     int                   `toml:"bandwidth"`
+  FailureRate   float64               `toml:"failure_rate"`
+  FailureStatus []int                 `toml:"failure_status"`
 }
 
 /**
@@ -157,8 +375,10 @@ type stylesheetConfig struct {
  * Javascript config
  */
 type javascriptConfig struct {
-  Minify    *bool                     `toml:"minify"`
-  Exclude   *[]string                 `toml:"exclude"`
+  Minify            *bool             `toml:"minify"`
+  Exclude           *[]string         `toml:"exclude"`
+  CircularImports   *string           `toml:"circular_imports"`
+  LegacyDirectives  *bool             `toml:"legacy_directives"`
 }
 
 /**
@@ -169,13 +389,78 @@ type unmanagedConfig struct {
   Exclude   *[]string                 `toml:"exclude_from_copy"`
 }
 
+/**
+ * Build config
+ */
+type buildConfig struct {
+  Compress          *[]string       `toml:"compress"`
+  CompressThreshold *int64          `toml:"compress_threshold"`
+  KeepGoing         *bool           `toml:"keep_going"`
+  Stage             *bool           `toml:"stage"`
+  Report            *bool           `toml:"report"`
+}
+
+/**
+ * Remote import config
+ */
+type importsConfig struct {
+  CacheRoot         *string         `toml:"cache_root"`
+  Lockfile          *string         `toml:"lockfile"`
+  Timeout           *string         `toml:"timeout"`
+  Offline           *bool           `toml:"offline"`
+}
+
+/**
+ * Vendoring config
+ */
+type vendorConfig struct {
+  Path              *string         `toml:"path"`
+  Rewrite           *bool           `toml:"rewrite"`
+}
+
 /**
  * Initialize options
  */
 func InitOptions(configPath string, inputPaths []string) (*Options) {
+  
+  options, err := LoadOptions(configPath, inputPaths)
+  if err != nil {
+    fmt.Println(err)
+    os.Exit(EXIT_CONFIG)
+  }
+  
+  // setup shared options
+  SetSharedOptions(options)
+  
+  return options
+}
+
+/**
+ * Load options without making them the shared options
+ */
+func LoadOptions(configPath string, inputPaths []string) (*Options, error) {
   var requireConfig bool
   options := &Options{}
   
+  // give requests in flight a moment to finish when we shut down
+  options.Server.ShutdownTimeout = 10 * time.Second
+  
+  // proxied responses are rewritten to our origin by default
+  options.Proxy.RewriteLocation = true
+  options.Proxy.RewriteCookies = true
+  
+  // only the most recent traffic is recorded so a long session doesn't grow without bound
+  options.Server.HARLimit = 10000
+  
+  // compiled text responses are compressed when the client supports it
+  options.Server.Compress = true
+  
+  // small files aren't worth precompressing
+  options.Build.CompressThreshold = 1024
+  
+  // remote imports are vendored here by default
+  options.Vendor.Path = VENDOR_PATH_DEFAULT
+  
   // where are we?
   binary, err := osext.Executable()
   if err != nil { panic(err) }
@@ -195,23 +480,18 @@ func InitOptions(configPath string, inputPaths []string) (*Options) {
   // check out our file
   if _, err := os.Stat(configPath); err != nil {
     if !os.IsNotExist(err) {
-      fmt.Printf("Could not stat configuration: %v\n", err)
-      os.Exit(-1)
+      return nil, fmt.Errorf("Could not stat configuration: %v", err)
     }else if requireConfig {
-      fmt.Printf("No such configuration: %v\n", err)
-      os.Exit(-1)
+      return nil, fmt.Errorf("No such configuration: %v", err)
     }
   }else{
     if err := options.loadOptions(configPath); err != nil {
-      fmt.Println(err)
-      os.Exit(-1)
+      return nil, err
     }
+    options.config = configPath
   }
   
-  // setup shared options
-  __options = options
-  
-  return options
+  return options, nil
 }
 
 /**
@@ -245,19 +525,125 @@ func (o *Options) loadOptions(configPath string) error {
   if conf.Server.Port != nil  { o.Server.Port = *conf.Server.Port }
   if conf.Server.Proxy != nil { o.Server.Proxy = *conf.Server.Proxy }
   if conf.Server.Root != nil  { o.Server.Root = *conf.Server.Root }
+  if conf.Server.Listen != nil { o.Server.Listen = *conf.Server.Listen }
+  if conf.Server.HAR != nil   { o.Server.HAR = *conf.Server.HAR }
+  if conf.Server.HARLimit != nil { o.Server.HARLimit = *conf.Server.HARLimit }
+  if conf.Server.HTTPS != nil { o.Server.HTTPS = *conf.Server.HTTPS }
+  if conf.Server.Redirect != nil { o.Server.Redirect = *conf.Server.Redirect }
+  if conf.Server.Hostnames != nil { o.Server.Hostnames = append(o.Server.Hostnames, *conf.Server.Hostnames...) }
+  if conf.Server.Certificate != nil { o.Server.Certificate = *conf.Server.Certificate }
+  if conf.Server.Key != nil { o.Server.Key = *conf.Server.Key }
+  if conf.Server.Certificates != nil { o.Server.Certificates = *conf.Server.Certificates }
+  if conf.Server.ShutdownTimeout != nil {
+    if o.Server.ShutdownTimeout, err = time.ParseDuration(*conf.Server.ShutdownTimeout); err != nil {
+      return fmt.Errorf("Shutdown timeout is not valid: %v", err)
+    }
+  }
+  if conf.Server.Fallback != nil { o.Server.Fallback = *conf.Server.Fallback }
+  if conf.Server.Listing != nil { o.Server.Listing = *conf.Server.Listing }
+  if conf.Server.Compress != nil { o.Server.Compress = *conf.Server.Compress }
+  
+  // initialize proxy config
+  if conf.Proxy.RewriteLocation != nil { o.Proxy.RewriteLocation = *conf.Proxy.RewriteLocation }
+  if conf.Proxy.RewriteCookies != nil { o.Proxy.RewriteCookies = *conf.Proxy.RewriteCookies }
+  if conf.Proxy.CORS != nil { o.Proxy.CORS = *conf.Proxy.CORS }
+  if conf.Proxy.CORSOrigin != nil { o.Proxy.CORSOrigin = *conf.Proxy.CORSOrigin }
+  if conf.Proxy.Cache != nil { o.Proxy.Cache = *conf.Proxy.Cache }
+  if conf.Proxy.CacheRoot != nil { o.Proxy.CacheRoot = *conf.Proxy.CacheRoot }
+  if conf.Proxy.CacheHeaders != nil { o.Proxy.CacheHeaders = append(o.Proxy.CacheHeaders, *conf.Proxy.CacheHeaders...) }
   
   // initialize JS config
   if conf.Javascript.Minify != nil { o.Javascript.Minify = *conf.Javascript.Minify }
   if conf.Javascript.Exclude != nil { o.Javascript.Exclude = append(o.Javascript.Exclude, *conf.Javascript.Exclude...) }
+  if conf.Javascript.LegacyDirectives != nil { o.Javascript.LegacyDirectives = *conf.Javascript.LegacyDirectives }
+  if conf.Javascript.CircularImports != nil {
+    switch *conf.Javascript.CircularImports {
+      case SeverityError, SeverityWarning:
+        o.Javascript.CircularImports = *conf.Javascript.CircularImports
+      default:
+        return fmt.Errorf("Circular imports must be reported as 'error' or 'warning': %s", *conf.Javascript.CircularImports)
+    }
+  }
   
   // initialize CSS config
   if conf.Stylesheet.Minify != nil { o.Stylesheet.Minify = *conf.Stylesheet.Minify }
   if conf.Stylesheet.Exclude != nil { o.Stylesheet.Exclude = append(o.Stylesheet.Exclude, *conf.Stylesheet.Exclude...) }
   
+  // initialize build config
+  if conf.Build.Compress != nil { o.Build.Compress = *conf.Build.Compress }
+  if conf.Build.CompressThreshold != nil { o.Build.CompressThreshold = *conf.Build.CompressThreshold }
+  if conf.Build.KeepGoing != nil { o.Build.KeepGoing = *conf.Build.KeepGoing }
+  if conf.Build.Stage != nil { o.Build.Stage = *conf.Build.Stage }
+  if conf.Build.Report != nil { o.Build.Report = *conf.Build.Report }
+  for _, e := range o.Build.Compress {
+    if _, ok := ENCODING_EXTENSIONS[e]; !ok {
+      return fmt.Errorf("Compression format is not supported: %s", e)
+    }
+  }
+  
+  // initialize remote import config
+  if conf.Imports.CacheRoot != nil { o.Imports.CacheRoot = *conf.Imports.CacheRoot }
+  if conf.Imports.Lockfile != nil { o.Imports.Lockfile = *conf.Imports.Lockfile }
+  if conf.Imports.Offline != nil { o.Imports.Offline = *conf.Imports.Offline }
+  if conf.Imports.Timeout != nil {
+    if o.Imports.Timeout, err = time.ParseDuration(*conf.Imports.Timeout); err != nil {
+      return fmt.Errorf("Import timeout is not valid: %v", err)
+    }
+  }
+  
+  // initialize vendoring config
+  if conf.Vendor.Path != nil { o.Vendor.Path = *conf.Vendor.Path }
+  if conf.Vendor.Rewrite != nil { o.Vendor.Rewrite = *conf.Vendor.Rewrite }
+  
   // initialize unmanaged config
   if conf.Unmanaged.Copy != nil { o.Unmanaged.Copy = *conf.Unmanaged.Copy }
   if conf.Unmanaged.Exclude != nil { o.Unmanaged.Exclude = append(o.Unmanaged.Exclude, *conf.Unmanaged.Exclude...) }
   
+  // initialize mock endpoints
+  for _, e := range conf.Mocks {
+    var latency time.Duration
+    if e.Latency != "" {
+      if latency, err = time.ParseDuration(e.Latency); err != nil {
+        return fmt.Errorf("Mock latency is not valid: %s: %v", e.Path, err)
+      }
+    }
+    o.Mocks = append(o.Mocks, MockOptions{e.Method, e.Path, e.File, e.Body, e.Status, e.Headers, latency})
+  }
+  
+  // initialize response headers
+  for _, e := range conf.Headers {
+    if e.Path == "" {
+      return fmt.Errorf("Headers must define a path")
+    }
+    o.Headers = append(o.Headers, HeaderOptions{e.Path, e.Values, e.Proxied})
+  }
+  
+  // initialize size budgets; the order isn't significant, but we keep it stable
+  patterns := make([]string, 0, len(conf.Budgets))
+  for k := range conf.Budgets {
+    patterns = append(patterns, k)
+  }
+  sort.Strings(patterns)
+  for _, e := range patterns {
+    if b, err := parseBudget(e, conf.Budgets[e]); err != nil {
+      return err
+    }else{
+      o.Budgets = append(o.Budgets, b)
+    }
+  }
+  
+  // initialize network simulation
+  if o.Network.NetworkConditions, err = parseNetworkConditions(conf.Network.Latency, conf.Network.Jitter, conf.Network.Bandwidth, conf.Network.FailureRate, conf.Network.FailureStatus); err != nil {
+    return err
+  }
+  for _, e := range conf.Network.Routes {
+    if c, err := parseNetworkConditions(e.Latency, e.Jitter, e.Bandwidth, e.FailureRate, e.FailureStatus); err != nil {
+      return fmt.Errorf("%v: %s", err, e.Path)
+    }else{
+      o.Network.Routes = append(o.Network.Routes, NetworkRoute{e.Path, c})
+    }
+  }
+  
   // initialize routes
   if o.Routes == nil {
     o.Routes = make(map[string][]string)
@@ -274,13 +660,123 @@ func (o *Options) loadOptions(configPath string) error {
     }
   }
   
+  // initialize route rules, in the order they are declared
+  for _, e := range conf.RouteRules {
+    if r, err := parseRouteRule(e); err != nil {
+      return err
+    }else{
+      o.RouteRules = append(o.RouteRules, r)
+    }
+  }
+  
   return nil
 }
 
+/**
+ * Parse a route rule. Exactly one of prefix, glob, or regex must be provided.
+ */
+func parseRouteRule(c routeConfig) (RouteOptions, error) {
+  var match, pattern string
+  var count int
+  
+  if c.Prefix != "" { match, pattern = ROUTE_MATCH_PREFIX, c.Prefix; count++ }
+  if c.Glob != ""   { match, pattern = ROUTE_MATCH_GLOB, c.Glob; count++ }
+  if c.Regex != ""  { match, pattern = ROUTE_MATCH_REGEX, c.Regex; count++ }
+  
+  if count != 1 {
+    return RouteOptions{}, fmt.Errorf("Route must define exactly one of 'prefix', 'glob', or 'regex'")
+  }
+  
+  var targets []string
+  switch v := c.To.(type) {
+    case string:
+      targets = []string{v}
+    case []interface{}:
+      if a, err := arrayToRoutes(v); err != nil {
+        return RouteOptions{}, fmt.Errorf("Route targets are not valid: %s: %v", pattern, err)
+      }else{
+        targets = a
+      }
+    case nil:
+      return RouteOptions{}, fmt.Errorf("Route does not define any targets: %s", pattern)
+    default:
+      return RouteOptions{}, fmt.Errorf("Route targets are not valid: %s: Type is not supported: %v", pattern, reflect.TypeOf(v))
+  }
+  
+  return RouteOptions{match, pattern, targets, c.Strict, c.Proxy != nil && !*c.Proxy}, nil
+}
+
+/**
+ * Parse a size budget. A budget is either a size, which limits the size of the
+ * output, or a table which limits its 'size' and/or 'gzip' size.
+ */
+func parseBudget(pattern string, v interface{}) (BudgetOptions, error) {
+  var err error
+  budget := BudgetOptions{Pattern: pattern}
+  
+  switch c := v.(type) {
+    case map[string]interface{}:
+      for k, e := range c {
+        switch k {
+          case "size":
+            budget.Size, err = parseSize(e)
+          case "gzip":
+            budget.Gzip, err = parseSize(e)
+          default:
+            return BudgetOptions{}, fmt.Errorf("Budget is not valid: %s: Unknown limit: %s", pattern, k)
+        }
+        if err != nil {
+          return BudgetOptions{}, fmt.Errorf("Budget is not valid: %s: %v", pattern, err)
+        }
+      }
+    default:
+      if budget.Size, err = parseSize(c); err != nil {
+        return BudgetOptions{}, fmt.Errorf("Budget is not valid: %s: %v", pattern, err)
+      }
+  }
+  
+  return budget, nil
+}
+
+/**
+ * Parse a size, which is either a number of bytes or a string like '250KB' or
+ * '1.5MB'. Units are multiples of 1024.
+ */
+func parseSize(v interface{}) (int64, error) {
+  switch c := v.(type) {
+    case int64:
+      return c, nil
+    case string:
+      units := []struct{ suffix string; scale float64 }{
+        {"GB", 1 << 30},
+        {"MB", 1 << 20},
+        {"KB", 1 << 10},
+        {"B", 1},
+      }
+      text := strings.ToUpper(strings.TrimSpace(c))
+      scale := float64(1)
+      for _, e := range units {
+        if strings.HasSuffix(text, e.suffix) {
+          text, scale = strings.TrimSpace(text[:len(text)-len(e.suffix)]), e.scale
+          break
+        }
+      }
+      if n, err := strconv.ParseFloat(text, 64); err != nil || n < 0 {
+        return 0, fmt.Errorf("Size is not valid: %s", c)
+      }else{
+        return int64(n * scale), nil
+      }
+    default:
+      return 0, fmt.Errorf("Size is not valid: %v", v)
+  }
+}
+
 /**
  * Obtain the shared options
  */
 func SharedOptions() (*Options) {
+  __optionsLock.RLock()
+  defer __optionsLock.RUnlock()
   if __options != nil {
     return __options
   }else{
@@ -288,6 +784,16 @@ func SharedOptions() (*Options) {
   }
 }
 
+/**
+ * Set the shared options. Options should not be modified once they are shared, they
+ * are replaced instead.
+ */
+func SetSharedOptions(options *Options) {
+  __optionsLock.Lock()
+  defer __optionsLock.Unlock()
+  __options = options
+}
+
 /**
  * Obtain our home path
  */
@@ -295,6 +801,13 @@ func (o *Options) Home() string {
   return o.home
 }
 
+/**
+ * Obtain the path to the configuration file these options were loaded from, if any
+ */
+func (o *Options) ConfigPath() string {
+  return o.config
+}
+
 /**
  * Obtain a resource path
  */
@@ -324,13 +837,20 @@ func (o *Options) SetFlag(flag int, set bool) {
  * Determine whether the specified resource should be excluded from compilation
  */
 func (o *Options) ShouldExclude(resource string) bool {
+  return o.ExcludePattern(resource) != ""
+}
+
+/**
+ * Obtain the pattern which excludes the specified resource from compilation, if any
+ */
+func (o *Options) ExcludePattern(resource string) string {
   switch path.Ext(resource) {
     case ".scss", ".css":
-      return shouldExclude(resource, o.Stylesheet.Exclude)
+      return excludePattern(resource, o.Stylesheet.Exclude)
     case ".ejs", ".js":
-      return shouldExclude(resource, o.Javascript.Exclude)
+      return excludePattern(resource, o.Javascript.Exclude)
     default:
-      return false
+      return ""
   }
 }
 
@@ -338,18 +858,46 @@ func (o *Options) ShouldExclude(resource string) bool {
  * Determine whether the specified resource should be excluded from compilation
  */
 func shouldExclude(resource string, patterns []string) bool {
+  return excludePattern(resource, patterns) != ""
+}
+
+/**
+ * Obtain the first pattern which matches the specified resource, if any
+ */
+func excludePattern(resource string, patterns []string) string {
   name := path.Base(resource)
   
   for _, p := range patterns {
     if match, err := path.Match(p, name); err != nil {
       log.Printf("ERROR: exclude resource shell pattern is invalid: '%s' %v; ignoring", p, err)
-      return false
+      return ""
     }else if match {
-      return true
+      return p
     }
   }
   
-  return false
+  return ""
+}
+
+/**
+ * Parse network conditions
+ */
+func parseNetworkConditions(latency, jitter string, bandwidth int, failureRate float64, failureStatus []int) (NetworkConditions, error) {
+  var err error
+  c := NetworkConditions{Bandwidth: bandwidth, FailureRate: failureRate, FailureStatus: failureStatus}
+  
+  if latency != "" {
+    if c.Latency, err = time.ParseDuration(latency); err != nil {
+      return c, fmt.Errorf("Network latency is not valid: %v", err)
+    }
+  }
+  if jitter != "" {
+    if c.Jitter, err = time.ParseDuration(jitter); err != nil {
+      return c, fmt.Errorf("Network jitter is not valid: %v", err)
+    }
+  }
+  
+  return c, c.Validate()
 }
 
 /**
@@ -34,8 +34,12 @@ import (
   "os"
   "fmt"
   "log"
+  "net"
+  "bytes"
   "path"
   "time"
+  "sync"
+  "context"
   "strings"
 )
 
@@ -47,6 +51,7 @@ import (
 import (
   "net/url"
   "net/http"
+  "crypto/tls"
   "html"
   "html/template"
 )
@@ -61,6 +66,8 @@ var MIMETYPES = map[string]string {
   ".js":    "application/javascript",
   ".ghtml": "text/html",
   ".html":  "text/html",
+  ".json":  "application/json",
+  ".txt":   "text/plain",
 }
 
 /**
@@ -78,20 +85,37 @@ var MANAGED_EXTENSIONS = map[string]bool {
  * A server
  */
 type Server struct {
+  options *Options
   port    int
   peer    *url.URL
   root    string
-  routes  map[string][]string
+  rules   []*routeRule
+  headers []*headerRule
   proxy   *ReverseProxy
   strict  bool
+  mocks   []*mockRoute
+  network *networkSimulator
+  traffic *harRecorder
+  console *dashboard
+  current *swappableHandler
+  servers []*http.Server
+  lock    sync.Mutex
 }
 
 /**
- * Create a server
+ * Create a server. The server is configured by, and serves requests with, the
+ * provided options rather than the shared options.
  */
-func NewServer(port int, peer, root string, routes map[string][]string) (*Server, error) {
+func NewServer(options *Options, root string) (*Server, error) {
+  port, peer, routes := options.Server.Port, options.Server.Proxy, options.Routes
   var proxy *ReverseProxy = nil
   var peerURL *url.URL = nil
+  var traffic *harRecorder = nil
+  
+  headers, err := newHeaderRules(options.Headers, options.Variables)
+  if err != nil {
+    return nil, err
+  }
   
   if peer != "" {
     var err error
@@ -99,40 +123,226 @@ func NewServer(port int, peer, root string, routes map[string][]string) (*Server
       return nil, err
     }else{
       proxy = NewSingleHostReverseProxy(peerURL)
+      rewriter := newProxyRewriter(peerURL, options.Proxy)
+      proxy.ModifyResponse = func(request *http.Request, response *http.Response) error {
+        if err := rewriter.rewriteResponse(request, response); err != nil {
+          return err
+        }
+        applyHeaders(headers, response.Header, request.URL.Path, true) // the path as requested, not as proxied
+        return nil
+      }
     }
+    if mode := options.Proxy.Cache; mode != ProxyCacheModeNone {
+      if proxy.Transport, err = newProxyCacheTransport(mode, options.Proxy.CacheRoot, options.Proxy.CacheHeaders, nil); err != nil {
+        return nil, err
+      }
+    }
+  }
+  
+  rules, err := newRouteRules(options.RouteRules, routes)
+  if err != nil {
+    return nil, err
+  }
+  
+  mocks, err := newMockRoutes(options.Mocks)
+  if err != nil {
+    return nil, err
+  }
+  
+  if options.Server.HAR != "" {
+    traffic = newHARRecorder(options.Server.HARLimit)
   }
   
-  return &Server{port, peerURL, root, routes, proxy, false}, nil
+  server := &Server{options:options, port:port, peer:peerURL, root:root, rules:rules, headers:headers, proxy:proxy, mocks:mocks, traffic:traffic, console:newDashboard(root)}
+  server.network = newNetworkSimulator(options.Network, server.serveError)
+  
+  return server, nil
 }
 
 /**
- * Run the server
+ * Obtain the handler for requests to this server
  */
-func (s *Server) Run() error {
+func (s *Server) Handler() http.Handler {
   
   mux := http.NewServeMux()
+  mux.HandleFunc("/", s.handler)
+  mux.Handle(SLANG_PREFIX, s.console)
+  mux.Handle(NETWORK_ENDPOINT, s.network)
   
-  if s.proxy != nil {
-    mux.HandleFunc("/", s.handler)
-  }else{
-    mux.HandleFunc("/", s.serveRequest)
+  if s.traffic != nil {
+    mux.Handle(HAR_ENDPOINT, s.traffic)
+  }
+  
+  return s.network.wrap(mux)
+}
+
+/**
+ * Run the server
+ */
+func (s *Server) Run() error {
+  
+  observers := []requestObserver{s.console}
+  if s.traffic != nil {
+    observers = append(observers, s.traffic)
   }
   
+  s.current = newSwappableHandler(s.Handler())
   server := &http.Server{
-    Addr: fmt.Sprintf(":%d", s.port),
-    Handler: mux,
+    Handler: traceHandler(s.current, observers...),
     ReadTimeout: 30 * time.Second,
     WriteTimeout: 30 * time.Second,
   }
   
-  return server.ListenAndServe()
+  s.addServer(server)
+  
+  options := s.options.Server
+  serve := server.Serve
+  
+  if options.HTTPS {
+    cert, err := loadCertificate(options)
+    if err != nil {
+      return fmt.Errorf("Could not load certificate: %v", err)
+    }
+    // HTTP/2 is enabled automatically when serving TLS
+    server.TLSConfig = &tls.Config{
+      Certificates: []tls.Certificate{cert},
+      MinVersion: tls.VersionTLS12,
+    }
+    serve = func(l net.Listener) error {
+      return server.ServeTLS(l, "", "")
+    }
+  }
+  
+  addresses := options.Listen
+  if len(addresses) < 1 {
+    addresses = []string{fmt.Sprintf(":%d", s.port)}
+  }
+  
+  listeners, err := listen(addresses)
+  if err != nil {
+    return err
+  }
+  
+  if options.HTTPS && options.Redirect > 0 {
+    // redirect to the port we're actually serving HTTPS on
+    port, ok := listenerPort(listeners)
+    if !ok {
+      for _, e := range listeners { e.Close() }
+      return fmt.Errorf("Cannot redirect HTTP to HTTPS without a TCP address to redirect to")
+    }
+    // and listen for HTTP on the same hosts we're serving HTTPS on
+    var redirects []net.Listener
+    for _, e := range redirectAddresses(listeners, options.Redirect) {
+      if l, err := listenAddress(e); err != nil {
+        for _, c := range append(listeners, redirects...) { c.Close() }
+        return fmt.Errorf("Could not redirect HTTP: %v", err)
+      }else{
+        redirects = append(redirects, l)
+      }
+    }
+    redirect := &http.Server{Handler: httpsRedirectHandler(port)}
+    s.addServer(redirect)
+    for _, e := range redirects {
+      if s.options.GetFlag(OptionsFlagVerbose) { log.Printf("Redirecting HTTP on: %s", listenerAddress(e)) }
+      go func(l net.Listener) {
+        if err := redirect.Serve(l); err != nil && err != http.ErrServerClosed {
+          log.Printf("ERROR: Could not redirect HTTP: %v", err)
+        }
+      }(e)
+    }
+  }
+  
+  // every listener is served by the same handler; the first one to fail stops us
+  errs := make(chan error, len(listeners))
+  for _, e := range listeners {
+    if s.options.GetFlag(OptionsFlagVerbose) { log.Printf("Listening on: %s", listenerAddress(e)) }
+    go func(l net.Listener) {
+      errs <- serve(l)
+    }(e)
+  }
+  
+  return <- errs
+}
+
+/**
+ * Note an underlying HTTP server so that it can be shut down
+ */
+func (s *Server) addServer(server *http.Server) {
+  s.lock.Lock()
+  defer s.lock.Unlock()
+  s.servers = append(s.servers, server)
+}
+
+/**
+ * Replace the configuration of a running server with that of another server, which
+ * has not been run. Requests in flight are completed by the handler they started
+ * with; subsequent requests are handled by the replacement. Recorded traffic, the
+ * dashboard, and network conditions set at runtime are carried over.
+ */
+func (s *Server) Reload(replacement *Server) {
+  replacement.traffic = s.traffic
+  replacement.console = s.console
+  replacement.console.setRoot(replacement.root)
+  replacement.network.inherit(s.network)
+  s.network = replacement.network
+  s.current.set(replacement.Handler())
+}
+
+/**
+ * Gracefully shut down the server. The server stops accepting connections and waits
+ * for requests in flight to complete, up to the specified timeout, after which any
+ * remaining connections are closed. The server is then finalized.
+ */
+func (s *Server) Shutdown(timeout time.Duration) error {
+  var err error
+  
+  s.lock.Lock()
+  servers := s.servers
+  s.lock.Unlock()
+  
+  ctx, cancel := context.WithTimeout(context.Background(), timeout)
+  defer cancel()
+  
+  for _, e := range servers {
+    if serr := e.Shutdown(ctx); serr != nil {
+      log.Printf("ERROR: Requests did not finish in time; closing connections: %v", serr)
+      e.Close()
+    }
+  }
+  
+  if cerr := s.Close(); cerr != nil {
+    err = cerr
+  }
+  
+  return err
+}
+
+/**
+ * Finalize the server. If traffic is being recorded it is written out.
+ */
+func (s *Server) Close() error {
+  if s.traffic != nil {
+    if err := s.traffic.WriteFile(s.options.Server.HAR); err != nil {
+      return err
+    }
+    if !s.options.GetFlag(OptionsFlagQuiet) { log.Printf("Traffic written to: %s", s.options.Server.HAR) }
+  }
+  return nil
 }
 
 /**
  * Handle a request
  */
 func (s *Server) handler(writer http.ResponseWriter, request *http.Request) {
-  if request.Method == "GET" && CanCompile(nil, request.URL.Path) {
+  if mock, params := matchMockRoute(s.mocks, request); mock != nil {
+    s.serveMock(writer, request, mock, params)
+  }else if s.proxy == nil {
+    s.serveRequest(writer, request)
+  }else if matchRoutes(s.rules, request.URL.Path).NoProxy {
+    s.serveRequestWithOptions(writer, request, false)
+  }else if s.options.Proxy.CORS && isPreflightRequest(request) {
+    s.servePreflight(writer, request)
+  }else if request.Method == "GET" && CanCompile(nil, request.URL.Path) {
     s.serveRequest(writer, request)
   }else{
     s.proxyRequest(writer, request)
@@ -144,51 +354,67 @@ func (s *Server) handler(writer http.ResponseWriter, request *http.Request) {
  */
 func (s *Server) proxyRequest(writer http.ResponseWriter, request *http.Request) {
   
-  if s.proxy != nil && SharedOptions().GetFlag(OptionsFlagVerbose) {
-    if u, err := url.Parse(request.URL.Path); err == nil {
-      log.Printf("%s %s \u2192 %v", request.Method, request.URL.Path, s.peer.ResolveReference(u))
-    }
+  if s.proxy != nil && s.options.GetFlag(OptionsFlagVerbose) {
+    log.Printf("%s %s \u2192 %v", request.Method, request.URL.Path, proxyURL(s.peer, request.URL))
   }
   
+  traceForRequest(request).setSource(TraceSourceProxy)
+  
   if s.proxy == nil {
     s.serveError(writer, request, http.StatusBadGateway, fmt.Errorf("No proxy is configured for non-managed resource: %s", request.URL.Path))
   }else if err := s.proxy.ServeHTTP(writer, request); err == FileNotFoundError {
     s.serveRequestWithOptions(writer, request, false) // attempt to serve the local version
+  }else if _, ok := err.(*proxyCacheMissError); ok {
+    s.serveError(writer, request, http.StatusGatewayTimeout, err)
   }else if err != nil {
     s.serveError(writer, request, http.StatusBadGateway, err)
   }
   
 }
 
+/**
+ * Respond to a CORS preflight request directly, without consulting the proxy
+ */
+func (s *Server) servePreflight(writer http.ResponseWriter, request *http.Request) {
+  addCORSHeaders(request, writer.Header(), s.options.Proxy.CORSOrigin)
+  writer.WriteHeader(http.StatusNoContent)
+}
+
 /**
  * Route a request
  */
 func (s *Server) routeRequest(request *http.Request) ([]string, string, error) {
+  return s.routePath(request.URL.Path)
+}
+
+/**
+ * Route a path. Directory paths are routed to their index document.
+ */
+func (s *Server) routePath(absolute string) ([]string, string, error) {
   var candidates []string
   var mimetype string
   
-  absolute := request.URL.Path
-  alternates := make([]string, 0)
-  
-  for k, v := range s.routes {
-    if strings.HasPrefix(absolute, k) {
-      for _, e := range v {
-        alternates = append(alternates, path.Join(e, absolute[len(k):]))
-      }
-    }
+  if strings.HasSuffix(absolute, "/") {
+    absolute += "index.html"
   }
   
+  match := matchRoutes(s.rules, absolute)
+  
   ext := path.Ext(absolute)
-  relatives := make([]string, len(alternates))
-  bases := make([]string, len(alternates))
+  relatives := make([]string, len(match.Alternates))
+  bases := make([]string, len(match.Alternates))
   
-  for i, e := range alternates {
+  for i, e := range match.Alternates {
     r := path.Join(s.root, e[1:])
     relatives[i] = r
     bases[i] = r[:len(r) - len(ext)]
   }
   
-  bases = append(bases, path.Join(s.root, absolute[:len(absolute) - len(ext)]))
+  // resources compiled from the document root are served unless a strict route
+  // matched; other files must be routed explicitly
+  if !match.Strict {
+    bases = append(bases, path.Join(s.root, absolute[:len(absolute) - len(ext)]))
+  }
   
   extWithBases := func(b []string, ext string) []string {
     o := make([]string, len(b))
@@ -228,43 +454,75 @@ func (s *Server) serveRequest(writer http.ResponseWriter, request *http.Request)
  * Serve a request
  */
 func (s *Server) serveRequestWithOptions(writer http.ResponseWriter, request *http.Request, allowProxy bool) {
-  var candidates []string
-  var mimetype string
-  var file *os.File
-  var err error
   
-  if candidates, mimetype, err = s.routeRequest(request); err != nil {
-    s.serveError(writer, request, http.StatusNotFound, fmt.Errorf("Could not map resource: %s", request.URL.Path))
+  if s.serveResource(writer, request, request.URL.Path) {
+    return
+  }
+  
+  if allowProxy && !s.strict && s.proxy != nil {
+    s.proxyRequest(writer, request)
+    return
+  }
+  
+  if s.options.Server.Listing && strings.HasSuffix(request.URL.Path, "/") && s.serveListing(writer, request) {
+    return
+  }
+  
+  if fallback := s.fallbackDocument(request); fallback != "" && s.serveResource(writer, request, fallback) {
     return
   }
   
-  if SharedOptions().GetFlag(OptionsFlagVerbose) {
-    log.Printf("%s %s \u2192 {%s}", request.Method, request.URL.Path, strings.Join(candidates, ", "))
+  s.serveError(writer, request, http.StatusNotFound, fmt.Errorf("No such resource: %s", request.URL.Path))
+}
+
+/**
+ * Serve the local resource for the provided path, if there is one. If the
+ * resource is a directory the request is redirected to the directory path.
+ */
+func (s *Server) serveResource(writer http.ResponseWriter, request *http.Request, absolute string) bool {
+  candidates, mimetype, err := s.routePath(absolute)
+  if err != nil {
+    s.serveError(writer, request, http.StatusNotFound, fmt.Errorf("Could not map resource: %s", absolute))
+    return true
+  }
+  
+  if s.options.GetFlag(OptionsFlagVerbose) {
+    log.Printf("%s %s \u2192 {%s}", request.Method, absolute, strings.Join(candidates, ", "))
   }
   
+  traceForRequest(request).addCandidates(candidates)
+  
   for _, e := range candidates {
-    if file, err = os.Open(e); err == nil {
+    if info, err := os.Stat(e); err != nil || info.IsDir() {
+      continue
+    }
+    if file, err := os.Open(e); err == nil {
       defer file.Close()
-      if !SharedOptions().GetFlag(OptionsFlagQuiet) { log.Printf("%s %s \u2192 %s", request.Method, request.URL.Path, e) }
-      writer.Header().Add("Content-Type", mimetype)
-      s.compileAndServeFile(writer, request, file)
-      return
+      if !s.options.GetFlag(OptionsFlagQuiet) { log.Printf("%s %s \u2192 %s", request.Method, request.URL.Path, e) }
+      traceForRequest(request).setResource(e)
+      s.compileAndServeFile(writer, request, file, mimetype)
+      return true
     }
   }
   
-  if !allowProxy || s.strict || s.proxy == nil {
-    s.serveError(writer, request, http.StatusNotFound, fmt.Errorf("No such resource: %s", request.URL.Path))
-  }else{
-    s.proxyRequest(writer, request)
+  if absolute == request.URL.Path && !strings.HasSuffix(absolute, "/") {
+    for _, e := range s.routeDirectories(absolute) {
+      if info, err := os.Stat(e); err == nil && info.IsDir() {
+        s.redirectToDirectory(writer, request)
+        return true
+      }
+    }
   }
   
+  return false
 }
 
 /**
- * Serve a request
+ * Serve a request. The resource is compiled in full before anything is written
+ * so that a failure can be served as an error instead of a partial response.
  */
-func (s *Server) compileAndServeFile(writer http.ResponseWriter, request *http.Request, file *os.File) {
-  context := NewContext()
+func (s *Server) compileAndServeFile(writer http.ResponseWriter, request *http.Request, file *os.File, mimetype string) {
+  context := NewContextWithOptions(s.options)
   
   if fstat, err := file.Stat(); err != nil {
     s.serveError(writer, request, http.StatusBadRequest, fmt.Errorf("Could not stat file: %v", file.Name()))
@@ -277,9 +535,33 @@ func (s *Server) compileAndServeFile(writer http.ResponseWriter, request *http.R
   if compiler, err := NewCompiler(context, file.Name()); err != nil {
     s.serveError(writer, request, http.StatusBadRequest, fmt.Errorf("Resource is not supported: %v", file.Name()))
     return
-  }else if err := compiler.Compile(context, file.Name(), "", file, writer); err != nil {
-    s.serveError(writer, request, http.StatusInternalServerError, err)
-    return
+  }else{
+    output := &bytes.Buffer{}
+    start := time.Now()
+    err := compiler.Compile(context, file.Name(), "", file, output)
+    traceForRequest(request).setCompile(time.Since(start))
+    if err = context.Failure(err); err != nil {
+      s.serveError(writer, request, http.StatusInternalServerError, err)
+      return
+    }
+    for _, e := range context.Diagnostics() {
+      log.Printf("WARNING: %v", e)
+    }
+    
+    writer.Header().Add("Content-Type", mimetype)
+    applyHeaders(s.headers, writer.Header(), request.URL.Path, false)
+    if s.options.Server.Compress && isCompressible(mimetype) {
+      writer.Header().Add("Vary", "Accept-Encoding")
+      if encoding := negotiateEncoding(request.Header.Get("Accept-Encoding")); encoding != "" {
+        compressed := newCompressWriter(writer, encoding)
+        defer compressed.Close()
+        writer = compressed
+      }
+    }
+    
+    if _, err := writer.Write(output.Bytes()); err != nil {
+      log.Printf("ERROR: Could not write response: %v", err)
+    }
   }
   
 }
@@ -288,54 +570,47 @@ func (s *Server) compileAndServeFile(writer http.ResponseWriter, request *http.R
  * An error
  */
 type templateError struct {
-  Message   string
-  Source    []template.HTML
-  Base      int
+  Message   string              `json:"message"`
+  Source    []template.HTML     `json:"source,omitempty"`
+  Text      []string            `json:"-"`
+  Base      int                 `json:"line"`
 }
 
 /**
- * Serve an error
+ * Serve an error. Stylesheets and scripts which fail to compile are served in a
+ * form the browser will present; everything else gets an error page.
  */
 func (s *Server) serveError(writer http.ResponseWriter, request *http.Request, status int, problem error) {
   log.Println("ERROR:", problem)
-  if t, err := template.ParseFiles(SharedOptions().Resource("html/error.html")); err != nil {
+  traceForRequest(request).setError(problem)
+  
+  format := ERROR_FORMAT_HTML
+  if prefersJSON(request.Header.Get("Accept")) {
+    format = ERROR_FORMAT_JSON
+  }else if status == http.StatusInternalServerError {
+    format = errorFormat(request)
+  }
+  
+  switch format {
+    case ERROR_FORMAT_CSS:
+      s.serveStylesheetError(writer, request, status, problem)
+      return
+    case ERROR_FORMAT_JS:
+      s.serveScriptError(writer, request, status, problem)
+      return
+    case ERROR_FORMAT_JSON:
+      s.serveDiagnosticError(writer, request, status, problem)
+      return
+  }
+  
+  if t, err := template.ParseFiles(s.options.Resource("html/error.html")); err != nil {
     
     log.Printf("ERROR: Could not compile template: %v\n", err)
     writer.WriteHeader(status)
     writer.Write([]byte(problem.Error()))
     
   }else{
-    var issues []*templateError
-    var message string
-    
-    switch e := problem.(type) {
-      case *errors.Error:
-        message = e.Message()
-        problem = e.Cause()
-      case *ejs.SourceError:
-        message = "Compilation error"
-        // same error gets processed below
-      default:
-        message = e.Error()
-        problem = nil
-    }
-    
-    for problem != nil {
-      switch e := problem.(type) {
-        case *errors.Error:
-          issues = append(issues, &templateError{e.Message(), nil, 0})
-          problem = e.Cause()
-        case *ejs.SourceError:
-          lines := e.ExcerptLines("<span class=\"marker\">", "</span>", html.EscapeString, 3)
-          excerpt := make([]template.HTML, len(lines))
-          for i, l := range lines { excerpt[i] = template.HTML(l) }
-          issues = append(issues, &templateError{fmt.Sprintf("%s\n%s", e.Location(), e.Message()), excerpt, e.Line()})
-          problem = nil
-        default:
-          issues = append(issues, &templateError{e.Error(), nil, 0})
-          problem = nil
-      }
-    }
+    message, issues := errorIssues(problem)
     
     params := map[string]interface{} {
       "Title":    "Error",
@@ -344,10 +619,81 @@ func (s *Server) serveError(writer http.ResponseWriter, request *http.Request, s
       "Errors":   issues,
     }
     
-    writer.Header().Add("Content-Type", "text/html")
+    writer.Header().Set("Content-Type", "text/html")
     writer.WriteHeader(status)
     t.Execute(writer, params)
     
   }
 }
 
+/**
+ * Describe an error as a summary message and the chain of issues that caused it
+ */
+func errorIssues(problem error) (string, []*templateError) {
+  var message string
+  
+  switch e := problem.(type) {
+    case Diagnostics:
+      return fmt.Sprintf("Compilation failed with %s", e.Summary()), diagnosticIssues(e)
+    case *errors.Error:
+      message = e.Message()
+      problem = e.Cause()
+    case *ejs.SourceError:
+      message = "Compilation error"
+      // same error gets processed below
+    default:
+      message = e.Error()
+      problem = nil
+  }
+  
+  return message, chainIssues(problem)
+}
+
+/**
+ * Describe every diagnostic in a set as issues
+ */
+func diagnosticIssues(diagnostics Diagnostics) []*templateError {
+  var issues []*templateError
+  
+  for _, e := range diagnostics {
+    var chain []*templateError
+    if e.err != nil {
+      chain = chainIssues(e.err)
+    }else{
+      chain = []*templateError{&templateError{e.Message, nil, nil, 0}}
+    }
+    if len(chain) > 0 && e.Severity != SeverityError {
+      chain[0].Message = e.Severity +": "+ chain[0].Message
+    }
+    issues = append(issues, chain...)
+  }
+  
+  return issues
+}
+
+/**
+ * Describe an error and the chain of errors that caused it as issues
+ */
+func chainIssues(problem error) []*templateError {
+  var issues []*templateError
+  
+  for problem != nil {
+    switch e := problem.(type) {
+      case *errors.Error:
+        issues = append(issues, &templateError{e.Message(), nil, nil, 0})
+        problem = e.Cause()
+      case *ejs.SourceError:
+        lines := e.ExcerptLines("<span class=\"marker\">", "</span>", html.EscapeString, 3)
+        excerpt := make([]template.HTML, len(lines))
+        for i, l := range lines { excerpt[i] = template.HTML(l) }
+        issues = append(issues, &templateError{fmt.Sprintf("%s\n%s", e.Location(), e.Message()), excerpt, e.ExcerptLines("", "", nil, 3), e.Line()})
+        problem = nil
+      default:
+        issues = append(issues, &templateError{e.Error(), nil, nil, 0})
+        problem = nil
+    }
+  }
+  
+  return issues
+}
+
@@ -0,0 +1,198 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "io"
+  "strconv"
+  "strings"
+  "compress/gzip"
+)
+
+import (
+  "net/http"
+)
+
+import (
+  "github.com/andybalholm/brotli"
+)
+
+/**
+ * Content encodings
+ */
+const (
+  ENCODING_GZIP   = "gzip"
+  ENCODING_BROTLI = "br"
+)
+
+/**
+ * Content encodings, in order of preference
+ */
+var ENCODINGS = []string{ENCODING_BROTLI, ENCODING_GZIP}
+
+/**
+ * Encoded sibling extensions
+ */
+var ENCODING_EXTENSIONS = map[string]string {
+  ENCODING_GZIP:    ".gz",
+  ENCODING_BROTLI:  ".br",
+}
+
+/**
+ * Mimetypes which are worth compressing, in addition to text/*
+ */
+var COMPRESSIBLE_MIMETYPES = map[string]bool {
+  "application/javascript": true,
+  "application/json":       true,
+  "application/xml":        true,
+  "image/svg+xml":          true,
+}
+
+/**
+ * Determine whether content of the provided mimetype is worth compressing
+ */
+func isCompressible(mimetype string) bool {
+  if i := strings.Index(mimetype, ";"); i > -1 {
+    mimetype = mimetype[:i]
+  }
+  mimetype = strings.TrimSpace(strings.ToLower(mimetype))
+  return strings.HasPrefix(mimetype, "text/") || COMPRESSIBLE_MIMETYPES[mimetype]
+}
+
+/**
+ * Choose the preferred encoding acceptable to the client, if any
+ */
+func negotiateEncoding(accept string) string {
+  accepted := make(map[string]bool)
+  wildcard := false
+  
+  for _, e := range strings.Split(accept, ",") {
+    params := strings.Split(e, ";")
+    coding := strings.ToLower(strings.TrimSpace(params[0]))
+    q := 1.0
+    for _, p := range params[1:] {
+      if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+        if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+          q = v
+        }
+      }
+    }
+    if coding == "*" {
+      wildcard = q > 0
+    }else if coding != "" {
+      accepted[coding] = q > 0
+    }
+  }
+  
+  for _, e := range ENCODINGS {
+    if ok, present := accepted[e]; ok || (!present && wildcard) {
+      return e
+    }
+  }
+  
+  return ""
+}
+
+/**
+ * Create an encoder for the provided content encoding
+ */
+func newEncoder(encoding string, writer io.Writer) io.WriteCloser {
+  switch encoding {
+    case ENCODING_GZIP:
+      return gzip.NewWriter(writer)
+    case ENCODING_BROTLI:
+      return brotli.NewWriter(writer)
+    default:
+      return nil
+  }
+}
+
+/**
+ * A response writer which compresses the response body
+ */
+type compressWriter struct {
+  http.ResponseWriter
+  encoding  string
+  encoder   io.WriteCloser
+  header    bool
+}
+
+/**
+ * Create a compressing response writer
+ */
+func newCompressWriter(writer http.ResponseWriter, encoding string) *compressWriter {
+  return &compressWriter{ResponseWriter:writer, encoding:encoding}
+}
+
+/**
+ * Write the response header. Responses without a body, and those which are
+ * already encoded, are not compressed.
+ */
+func (w *compressWriter) WriteHeader(status int) {
+  if w.header {
+    return
+  }
+  
+  w.header = true
+  header := w.ResponseWriter.Header()
+  
+  if status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified && header.Get("Content-Encoding") == "" {
+    header.Set("Content-Encoding", w.encoding)
+    header.Del("Content-Length")
+    w.encoder = newEncoder(w.encoding, w.ResponseWriter)
+  }
+  
+  w.ResponseWriter.WriteHeader(status)
+}
+
+/**
+ * Write response data
+ */
+func (w *compressWriter) Write(data []byte) (int, error) {
+  if !w.header {
+    w.WriteHeader(http.StatusOK)
+  }
+  if w.encoder != nil {
+    return w.encoder.Write(data)
+  }else{
+    return w.ResponseWriter.Write(data)
+  }
+}
+
+/**
+ * Finish the compressed response
+ */
+func (w *compressWriter) Close() error {
+  if w.encoder != nil {
+    return w.encoder.Close()
+  }
+  return nil
+}
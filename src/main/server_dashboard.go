@@ -0,0 +1,348 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "fmt"
+  "log"
+  "sync"
+  "time"
+  "strings"
+  "path/filepath"
+)
+
+import (
+  "net/http"
+  "html/template"
+  "encoding/json"
+)
+
+const (
+  SLANG_PREFIX            = "/_slang/"
+  DASHBOARD_API_PREFIX    = SLANG_PREFIX +"api/"
+  DASHBOARD_HISTORY       = 200
+)
+
+/**
+ * A request, as reported by the dashboard
+ */
+type dashboardRequest struct {
+  Time        time.Time           `json:"time"`
+  Method      string              `json:"method"`
+  Path        string              `json:"path"`
+  Status      int                 `json:"status"`
+  Source      string              `json:"source"`
+  Candidates  []string            `json:"candidates"`
+  Resource    string              `json:"resource,omitempty"`
+  Duration    float64             `json:"duration"`
+  Compile     float64             `json:"compile"`
+  Error       string              `json:"error,omitempty"`
+}
+
+/**
+ * The most recent error produced for a resource
+ */
+type dashboardError struct {
+  Time        time.Time           `json:"time"`
+  Path        string              `json:"path"`
+  Resource    string              `json:"resource,omitempty"`
+  Error       string              `json:"error"`
+  Resolved    bool                `json:"resolved"`
+}
+
+/**
+ * Compilation statistics for a resource
+ */
+type dashboardCompile struct {
+  Resource    string              `json:"resource"`
+  Count       int                 `json:"count"`
+  Last        float64             `json:"last"`
+  Average     float64             `json:"average"`
+  total       time.Duration
+}
+
+/**
+ * Proxy cache state
+ */
+type dashboardCache struct {
+  Mode        string              `json:"mode"`
+  Root        string              `json:"root,omitempty"`
+  Entries     int                 `json:"entries"`
+}
+
+/**
+ * Routing state
+ */
+type dashboardRoutes struct {
+  Root        string              `json:"root"`
+  Proxy       string              `json:"proxy,omitempty"`
+  Routes      map[string][]string `json:"routes"`
+  Rules       []RouteOptions      `json:"rules"`
+  Mocks       []MockOptions       `json:"mocks"`
+}
+
+/**
+ * The complete dashboard status
+ */
+type dashboardStatus struct {
+  Version     string              `json:"version"`
+  Started     time.Time           `json:"started"`
+  Config      *Options            `json:"config"`
+  Routes      dashboardRoutes     `json:"routes"`
+  Cache       dashboardCache      `json:"cache"`
+  Requests    []*dashboardRequest `json:"requests"`
+  Errors      []*dashboardError   `json:"errors"`
+  Compiles    []*dashboardCompile `json:"compiles"`
+}
+
+/**
+ * The Slang dashboard. This observes requests handled by the server and reports on
+ * them, along with the server's configuration, as HTML and JSON.
+ */
+type dashboard struct {
+  sync.Mutex
+  started   time.Time
+  root      string
+  requests  []*dashboardRequest
+  errors    map[string]*dashboardError
+  compiles  map[string]*dashboardCompile
+}
+
+/**
+ * Create a dashboard
+ */
+func newDashboard(root string) *dashboard {
+  return &dashboard{started: time.Now(), root: root, errors: make(map[string]*dashboardError), compiles: make(map[string]*dashboardCompile)}
+}
+
+/**
+ * Set the document root reported by the dashboard
+ */
+func (d *dashboard) setRoot(root string) {
+  d.Lock()
+  defer d.Unlock()
+  d.root = root
+}
+
+/**
+ * Observe a request
+ */
+func (d *dashboard) observeRequest(trace *requestTrace) {
+  request := trace.Request
+  if strings.HasPrefix(request.URL.Path, SLANG_PREFIX) {
+    return // don't report on ourself
+  }
+  
+  var errmsg string
+  if trace.Error != nil {
+    errmsg = trace.Error.Error()
+  }
+  
+  d.Lock()
+  defer d.Unlock()
+  
+  d.requests = append(d.requests, &dashboardRequest{
+    Time: trace.Started,
+    Method: request.Method,
+    Path: request.URL.Path,
+    Status: trace.Status,
+    Source: trace.Source,
+    Candidates: trace.Candidates,
+    Resource: trace.Resource,
+    Duration: millis(trace.Duration()),
+    Compile: millis(trace.Compile),
+    Error: errmsg,
+  })
+  if l := len(d.requests); l > DASHBOARD_HISTORY {
+    d.requests = d.requests[l - DASHBOARD_HISTORY:]
+  }
+  
+  key := trace.Resource
+  if key == "" {
+    key = request.URL.Path
+  }
+  
+  if trace.Error != nil {
+    d.errors[key] = &dashboardError{trace.Started, request.URL.Path, trace.Resource, errmsg, false}
+  }else if e, ok := d.errors[key]; ok {
+    e.Resolved = true
+  }
+  
+  if trace.Resource != "" && trace.Compile > 0 {
+    c, ok := d.compiles[trace.Resource]
+    if !ok {
+      c = &dashboardCompile{Resource: trace.Resource}
+      d.compiles[trace.Resource] = c
+    }
+    c.Count++
+    c.total += trace.Compile
+    c.Last = millis(trace.Compile)
+    c.Average = millis(c.total / time.Duration(c.Count))
+  }
+  
+}
+
+/**
+ * Produce the current status
+ */
+func (d *dashboard) status() *dashboardStatus {
+  options := SharedOptions()
+  
+  d.Lock()
+  defer d.Unlock()
+  
+  // most recent first
+  requests := make([]*dashboardRequest, len(d.requests))
+  for i, e := range d.requests {
+    requests[len(requests) - i - 1] = e
+  }
+  
+  errors := make([]*dashboardError, 0, len(d.errors))
+  for _, e := range d.errors {
+    errors = append(errors, e)
+  }
+  
+  compiles := make([]*dashboardCompile, 0, len(d.compiles))
+  for _, e := range d.compiles {
+    compiles = append(compiles, e)
+  }
+  
+  return &dashboardStatus{
+    Version: VERSION,
+    Started: d.started,
+    Config: options,
+    Routes: dashboardRoutes{d.root, options.Server.Proxy, options.Routes, options.RouteRules, options.Mocks},
+    Cache: proxyCacheState(options.Proxy),
+    Requests: requests,
+    Errors: errors,
+    Compiles: compiles,
+  }
+}
+
+/**
+ * Describe the state of the proxy cache
+ */
+func proxyCacheState(options ProxyOptions) dashboardCache {
+  if options.Cache == ProxyCacheModeNone {
+    return dashboardCache{Mode: "off"}
+  }
+  
+  root := options.CacheRoot
+  if root == "" {
+    root = PROXY_CACHE_PATH_DEFAULT
+  }
+  
+  entries, _ := filepath.Glob(filepath.Join(root, "*.json"))
+  return dashboardCache{options.Cache, root, len(entries)}
+}
+
+/**
+ * Serve the dashboard
+ */
+func (d *dashboard) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+  if request.URL.Path == SLANG_PREFIX {
+    d.serveDashboard(writer, request)
+  }else if strings.HasPrefix(request.URL.Path, DASHBOARD_API_PREFIX) {
+    d.serveAPI(writer, request, request.URL.Path[len(DASHBOARD_API_PREFIX):])
+  }else{
+    http.NotFound(writer, request)
+  }
+}
+
+/**
+ * Serve the dashboard page
+ */
+func (d *dashboard) serveDashboard(writer http.ResponseWriter, request *http.Request) {
+  
+  t, err := template.New("dashboard.html").Funcs(template.FuncMap{"join": strings.Join}).ParseFiles(SharedOptions().Resource("html/dashboard.html"))
+  if err != nil {
+    log.Printf("ERROR: Could not compile template: %v\n", err)
+    http.Error(writer, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  
+  config, err := json.MarshalIndent(SharedOptions(), "", "  ")
+  if err != nil {
+    http.Error(writer, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  
+  params := map[string]interface{} {
+    "Title":    "Slang",
+    "Status":   d.status(),
+    "Config":   string(config),
+  }
+  
+  writer.Header().Set("Content-Type", "text/html")
+  if err := t.Execute(writer, params); err != nil {
+    log.Printf("ERROR: Could not render dashboard: %v\n", err)
+  }
+  
+}
+
+/**
+ * Serve the dashboard API. Every part of the status is available on its own, or all
+ * of it together via 'status'.
+ */
+func (d *dashboard) serveAPI(writer http.ResponseWriter, request *http.Request, endpoint string) {
+  var result interface{}
+  status := d.status()
+  
+  switch endpoint {
+    case "status":
+      result = status
+    case "config":
+      result = status.Config
+    case "routes":
+      result = status.Routes
+    case "cache":
+      result = status.Cache
+    case "requests":
+      result = status.Requests
+    case "errors":
+      result = status.Errors
+    case "compiles":
+      result = status.Compiles
+    default:
+      writer.Header().Set("Content-Type", "application/json")
+      writer.WriteHeader(http.StatusNotFound)
+      json.NewEncoder(writer).Encode(map[string]string{"error": fmt.Sprintf("No such endpoint: %s", endpoint)})
+      return
+  }
+  
+  writer.Header().Set("Content-Type", "application/json")
+  enc := json.NewEncoder(writer)
+  enc.SetIndent("", "  ")
+  if err := enc.Encode(result); err != nil {
+    log.Printf("ERROR: Could not encode status: %v\n", err)
+  }
+  
+}
@@ -0,0 +1,300 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "os"
+  "io"
+  "sync"
+  "time"
+  "strings"
+)
+
+import (
+  "net/http"
+  "encoding/json"
+)
+
+const (
+  HAR_ENDPOINT  = SLANG_PREFIX +"traffic.har"
+)
+
+/**
+ * An HTTP Archive (HAR 1.2) log. See: http://www.softwareishard.com/blog/har-12-spec/
+ */
+type harLog struct {
+  Version   string          `json:"version"`
+  Creator   harCreator      `json:"creator"`
+  Entries   []*harEntry     `json:"entries"`
+}
+
+type harCreator struct {
+  Name      string          `json:"name"`
+  Version   string          `json:"version"`
+}
+
+type harEntry struct {
+  Started   string          `json:"startedDateTime"`
+  Time      float64         `json:"time"`
+  Request   harRequest      `json:"request"`
+  Response  harResponse     `json:"response"`
+  Cache     struct{}        `json:"cache"`
+  Timings   harTimings      `json:"timings"`
+  Slang     harSlang        `json:"_slang"`
+}
+
+type harRequest struct {
+  Method      string        `json:"method"`
+  URL         string        `json:"url"`
+  HTTPVersion string        `json:"httpVersion"`
+  Cookies     []harPair     `json:"cookies"`
+  Headers     []harPair     `json:"headers"`
+  QueryString []harPair     `json:"queryString"`
+  HeadersSize int           `json:"headersSize"`
+  BodySize    int64         `json:"bodySize"`
+}
+
+type harResponse struct {
+  Status      int           `json:"status"`
+  StatusText  string        `json:"statusText"`
+  HTTPVersion string        `json:"httpVersion"`
+  Cookies     []harPair     `json:"cookies"`
+  Headers     []harPair     `json:"headers"`
+  Content     harContent    `json:"content"`
+  RedirectURL string        `json:"redirectURL"`
+  HeadersSize int           `json:"headersSize"`
+  BodySize    int64         `json:"bodySize"`
+}
+
+type harContent struct {
+  Size        int64         `json:"size"`
+  MimeType    string        `json:"mimeType"`
+}
+
+type harPair struct {
+  Name        string        `json:"name"`
+  Value       string        `json:"value"`
+}
+
+type harTimings struct {
+  Send        float64       `json:"send"`
+  Wait        float64       `json:"wait"`
+  Receive     float64       `json:"receive"`
+}
+
+/**
+ * Slang-specific entry details (custom HAR fields must begin with '_')
+ */
+type harSlang struct {
+  Source      string        `json:"source"`
+  Candidates  []string      `json:"candidates,omitempty"`
+  Resource    string        `json:"resource,omitempty"`
+  Compile     float64       `json:"compileTime"`
+  Error       string        `json:"error,omitempty"`
+}
+
+/**
+ * A HAR recorder. This observes requests handled by the server and records them in
+ * an HTTP Archive log. When a limit is set, entries are kept in a ring buffer so only
+ * the most recent requests are retained.
+ */
+type harRecorder struct {
+  sync.Mutex
+  entries   []*harEntry
+  limit     int
+  next      int
+}
+
+/**
+ * Create a HAR recorder that retains at most the specified number of entries, or all
+ * of them if the limit is zero
+ */
+func newHARRecorder(limit int) *harRecorder {
+  return &harRecorder{entries: make([]*harEntry, 0), limit: limit}
+}
+
+/**
+ * Record a request
+ */
+func (r *harRecorder) observeRequest(trace *requestTrace) {
+  if trace.Request.URL.Path == HAR_ENDPOINT {
+    return // don't record requests for the log itself
+  }
+  entry := newHAREntry(trace)
+  r.Lock()
+  defer r.Unlock()
+  if r.limit > 0 && len(r.entries) >= r.limit {
+    r.entries[r.next] = entry // overwrite the oldest entry
+    r.next = (r.next + 1) % len(r.entries)
+  }else{
+    r.entries = append(r.entries, entry)
+  }
+}
+
+/**
+ * Write the log
+ */
+func (r *harRecorder) Write(w io.Writer) error {
+  r.Lock()
+  defer r.Unlock()
+  // entries are written oldest first
+  entries := make([]*harEntry, 0, len(r.entries))
+  entries = append(entries, r.entries[r.next:]...)
+  entries = append(entries, r.entries[:r.next]...)
+  enc := json.NewEncoder(w)
+  enc.SetIndent("", "  ")
+  return enc.Encode(map[string]interface{}{"log": &harLog{"1.2", harCreator{"Slang", VERSION}, entries}})
+}
+
+/**
+ * Write the log to a file
+ */
+func (r *harRecorder) WriteFile(path string) error {
+  
+  file, err := os.OpenFile(path, os.O_WRONLY | os.O_CREATE | os.O_TRUNC, 0644)
+  if err != nil {
+    return err
+  }else{
+    defer file.Close()
+  }
+  
+  return r.Write(file)
+}
+
+/**
+ * Serve the log
+ */
+func (r *harRecorder) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+  writer.Header().Set("Content-Type", "application/json")
+  writer.Header().Set("Content-Disposition", "attachment; filename=\"slang.har\"")
+  r.Write(writer)
+}
+
+/**
+ * Create a HAR entry from a request trace
+ */
+func newHAREntry(trace *requestTrace) *harEntry {
+  request := trace.Request
+  
+  u := *request.URL
+  if u.Host == "" { u.Host = request.Host }
+  if u.Scheme == "" { u.Scheme = requestOrigin(request).Scheme }
+  
+  query := make([]harPair, 0)
+  for k, v := range request.URL.Query() {
+    for _, e := range v {
+      query = append(query, harPair{k, e})
+    }
+  }
+  
+  var errmsg string
+  if trace.Error != nil {
+    errmsg = trace.Error.Error()
+  }
+  
+  return &harEntry{
+    Started: trace.Started.Format(time.RFC3339Nano),
+    Time: millis(trace.Duration()),
+    Request: harRequest{
+      Method: request.Method,
+      URL: u.String(),
+      HTTPVersion: request.Proto,
+      Cookies: harCookies(request.Header["Cookie"], false),
+      Headers: harHeaders(request.Header),
+      QueryString: query,
+      HeadersSize: -1,
+      BodySize: request.ContentLength,
+    },
+    Response: harResponse{
+      Status: trace.Status,
+      StatusText: http.StatusText(trace.Status),
+      HTTPVersion: request.Proto,
+      Cookies: harCookies(trace.Header["Set-Cookie"], true),
+      Headers: harHeaders(trace.Header),
+      Content: harContent{trace.Size, trace.Header.Get("Content-Type")},
+      RedirectURL: trace.Header.Get("Location"),
+      HeadersSize: -1,
+      BodySize: trace.Size,
+    },
+    Timings: harTimings{
+      Send: 0,
+      Wait: millis(trace.Responded.Sub(trace.Started)),
+      Receive: millis(trace.Finished.Sub(trace.Responded)),
+    },
+    Slang: harSlang{
+      Source: trace.Source,
+      Candidates: trace.Candidates,
+      Resource: trace.Resource,
+      Compile: millis(trace.Compile),
+      Error: errmsg,
+    },
+  }
+}
+
+/**
+ * Convert headers to HAR pairs
+ */
+func harHeaders(header http.Header) []harPair {
+  pairs := make([]harPair, 0, len(header))
+  for k, v := range header {
+    for _, e := range v {
+      pairs = append(pairs, harPair{k, e})
+    }
+  }
+  return pairs
+}
+
+/**
+ * Convert Cookie or Set-Cookie header values to HAR pairs. Only the name and value of
+ * each cookie is recorded; attributes which follow a Set-Cookie pair are ignored.
+ */
+func harCookies(values []string, set bool) []harPair {
+  pairs := make([]harPair, 0)
+  for _, v := range values {
+    parts := strings.Split(v, ";")
+    if set {
+      parts = parts[:1]
+    }
+    for _, e := range parts {
+      if i := strings.Index(e, "="); i > 0 {
+        pairs = append(pairs, harPair{strings.TrimSpace(e[:i]), strings.TrimSpace(e[i+1:])})
+      }
+    }
+  }
+  return pairs
+}
+
+/**
+ * Convert a duration to fractional milliseconds
+ */
+func millis(d time.Duration) float64 {
+  return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,195 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "os"
+  "fmt"
+  "path"
+  "sort"
+  "bytes"
+  "regexp"
+  "strings"
+  "text/template"
+)
+
+import (
+  "net/http"
+)
+
+/**
+ * Header export formats
+ */
+const (
+  HEADERS_FORMAT_NETLIFY  = "netlify"
+  HEADERS_FORMAT_NGINX    = "nginx"
+)
+
+/**
+ * A set of headers applied to responses for matching paths
+ */
+type headerRule struct {
+  options   HeaderOptions
+  expr      *regexp.Regexp
+  values    map[string]string
+}
+
+/**
+ * Create header rules from their definitions. Header values are expanded as
+ * templates with access to the configured variables.
+ */
+func newHeaderRules(defs []HeaderOptions, variables map[string]interface{}) ([]*headerRule, error) {
+  rules := make([]*headerRule, len(defs))
+  
+  for i, e := range defs {
+    expr, err := regexp.Compile(globToRegexp(e.Path))
+    if err != nil {
+      return nil, fmt.Errorf("Header path is not valid: %s: %v", e.Path, err)
+    }
+    
+    values := make(map[string]string)
+    for k, v := range e.Values {
+      t, err := template.New(k).Option("missingkey=error").Parse(v)
+      if err != nil {
+        return nil, fmt.Errorf("Header is not valid: %s: %s: %v", e.Path, k, err)
+      }
+      b := &bytes.Buffer{}
+      if err := t.Execute(b, variables); err != nil {
+        return nil, fmt.Errorf("Header could not be expanded: %s: %s: %v", e.Path, k, err)
+      }
+      values[http.CanonicalHeaderKey(k)] = b.String()
+    }
+    
+    rules[i] = &headerRule{e, expr, values}
+  }
+  
+  return rules, nil
+}
+
+/**
+ * Apply the headers for a path. Rules are applied in the order they are declared,
+ * so a later rule overrides the values set by an earlier one. Proxied responses
+ * are only affected by rules which are explicitly applied to them.
+ */
+func applyHeaders(rules []*headerRule, header http.Header, absolute string, proxied bool) {
+  for _, e := range rules {
+    if proxied && !e.options.Proxied {
+      continue
+    }
+    if e.expr.MatchString(absolute) {
+      for k, v := range e.values {
+        header.Set(k, v)
+      }
+    }
+  }
+}
+
+/**
+ * Export header rules in a format understood by a production server
+ */
+func exportHeaders(rules []*headerRule, format, outbase string) (string, error) {
+  var outpath string
+  b := &bytes.Buffer{}
+  
+  switch format {
+    case HEADERS_FORMAT_NETLIFY:
+      outpath = path.Join(outbase, "_headers")
+      for _, e := range rules {
+        p, err := netlifyPath(e.options.Path)
+        if err != nil {
+          return "", err
+        }
+        fmt.Fprintf(b, "%s\n", p)
+        for _, k := range sortedHeaderNames(e.values) {
+          fmt.Fprintf(b, "  %s: %s\n", k, e.values[k])
+        }
+      }
+    case HEADERS_FORMAT_NGINX:
+      outpath = path.Join(outbase, "headers.nginx.conf")
+      fmt.Fprintf(b, "# Generated by Slang. Include this in the http block of your nginx configuration.\n")
+      fmt.Fprintf(b, "# Note that add_header directives are not inherited by a server or location\n# which defines its own.\n")
+      names := make(map[string]string)
+      for _, e := range rules {
+        for k, _ := range e.values {
+          names[k] = k
+        }
+      }
+      for i, k := range sortedHeaderNames(names) {
+        // map uses the first matching expression, so later rules, which override earlier ones, come first
+        fmt.Fprintf(b, "\nmap $uri $slang_header_%d {\n  default \"\";\n", i)
+        for j := len(rules) - 1; j >= 0; j-- {
+          if v, ok := rules[j].values[k]; ok {
+            fmt.Fprintf(b, "  \"~%s\" \"%s\";\n", rules[j].expr.String(), strings.Replace(v, "\"", "\\\"", -1))
+          }
+        }
+        fmt.Fprintf(b, "}\nadd_header %s $slang_header_%d always;\n", k, i)
+      }
+    default:
+      return "", fmt.Errorf("Header export format is not supported: %s", format)
+  }
+  
+  if err := os.MkdirAll(outbase, 0755); err != nil {
+    return "", err
+  }
+  
+  return outpath, writeOutput(outpath, b.Bytes())
+}
+
+/**
+ * Convert a header path to the form used by Netlify. A Netlify splat matches any
+ * number of path segments, so the only wildcard which can be expressed is a
+ * trailing '**'; anything else would match a different set of paths.
+ */
+func netlifyPath(glob string) (string, error) {
+  base := glob
+  if strings.HasSuffix(base, "**") {
+    base = base[:len(base)-2]
+  }
+  if strings.ContainsAny(base, "*?") {
+    return "", fmt.Errorf("Header path cannot be expressed in Netlify's format; only a trailing '**' is supported: %s", glob)
+  }
+  if base != glob {
+    return base +"*", nil
+  }
+  return glob, nil
+}
+
+/**
+ * Obtain header names in a stable order
+ */
+func sortedHeaderNames(values map[string]string) []string {
+  names := make([]string, 0, len(values))
+  for k, _ := range values {
+    names = append(names, k)
+  }
+  sort.Strings(names)
+  return names
+}
@@ -0,0 +1,202 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "os"
+  "log"
+  "path"
+  "sort"
+  "strings"
+  "io/ioutil"
+  "html/template"
+)
+
+import (
+  "net/url"
+  "net/http"
+)
+
+/**
+ * The names under which sources are served
+ */
+var SERVED_EXTENSIONS = map[string]string {
+  ".scss":  ".css",
+  ".ejs":   ".js",
+  ".ghtml": ".html",
+}
+
+/**
+ * An entry in a directory listing
+ */
+type listingEntry struct {
+  Name      string
+  Link      string
+  Directory bool
+  Size      int64
+  Source    string
+}
+
+/**
+ * Redirect a request for a directory to the directory path, with a trailing slash
+ */
+func (s *Server) redirectToDirectory(writer http.ResponseWriter, request *http.Request) {
+  u := &url.URL{Path:request.URL.Path +"/", RawQuery:request.URL.RawQuery}
+  http.Redirect(writer, request, u.String(), http.StatusFound)
+}
+
+/**
+ * Determine the index document to fall back to for a request, if any. Only
+ * navigations (paths without an extension, or .html documents) fall back; asset
+ * requests are not affected.
+ */
+func (s *Server) fallbackDocument(request *http.Request) string {
+  fallback := s.options.Server.Fallback
+  if fallback == "" {
+    return ""
+  }
+  
+  if request.Method != "GET" && request.Method != "HEAD" {
+    return ""
+  }
+  
+  switch path.Ext(request.URL.Path) {
+    case "", ".html":
+      // navigation
+    default:
+      return ""
+  }
+  
+  if accept := request.Header.Get("Accept"); accept != "" && !strings.Contains(accept, "text/html") && !strings.Contains(accept, "*/*") {
+    return ""
+  }
+  
+  if !strings.HasPrefix(fallback, "/") {
+    fallback = "/"+ fallback
+  }
+  if fallback == request.URL.Path {
+    return ""
+  }
+  
+  return fallback
+}
+
+/**
+ * Obtain the local directories a path may refer to, in order: those it is routed
+ * to, followed by the path relative to the document root unless a strict route
+ * matched.
+ */
+func (s *Server) routeDirectories(absolute string) []string {
+  match := matchRoutes(s.rules, absolute)
+  dirs := make([]string, 0, len(match.Alternates) + 1)
+  for _, e := range match.Alternates {
+    dirs = append(dirs, path.Join(s.root, e[1:]))
+  }
+  if !match.Strict {
+    dirs = append(dirs, path.Join(s.root, absolute[1:]))
+  }
+  return dirs
+}
+
+/**
+ * Serve a listing of the directory for a request, if it exists
+ */
+func (s *Server) serveListing(writer http.ResponseWriter, request *http.Request) bool {
+  var dir string
+  
+  for _, e := range s.routeDirectories(request.URL.Path) {
+    if info, err := os.Stat(e); err == nil && info.IsDir() {
+      dir = e
+      break
+    }
+  }
+  if dir == "" {
+    return false
+  }
+  
+  infos, err := ioutil.ReadDir(dir)
+  if err != nil {
+    return false
+  }
+  
+  entries := make([]listingEntry, 0, len(infos))
+  served := make(map[string]int)
+  
+  for _, e := range infos {
+    name := e.Name()
+    if strings.HasPrefix(name, ".") {
+      continue
+    }
+    
+    entry := listingEntry{Name:name, Directory:e.IsDir(), Size:e.Size()}
+    if entry.Directory {
+      entry.Name += "/"
+    }else if ext, ok := SERVED_EXTENSIONS[path.Ext(name)]; ok {
+      entry.Name = name[:len(name) - len(path.Ext(name))] + ext
+      entry.Source = name
+    }
+    
+    if i, ok := served[entry.Name]; ok {
+      if entry.Source != "" {
+        entries[i].Source = entry.Source // a source and its output are listed once, as the source
+      }
+      continue
+    }
+    
+    served[entry.Name] = len(entries)
+    entry.Link = (&url.URL{Path:entry.Name}).String()
+    entries = append(entries, entry)
+  }
+  
+  sort.Slice(entries, func(i, j int) bool {
+    if entries[i].Directory != entries[j].Directory {
+      return entries[i].Directory
+    }else{
+      return entries[i].Name < entries[j].Name
+    }
+  })
+  
+  t, err := template.ParseFiles(s.options.Resource("html/listing.html"))
+  if err != nil {
+    log.Printf("ERROR: Could not compile template: %v\n", err)
+    return false
+  }
+  
+  if !s.options.GetFlag(OptionsFlagQuiet) { log.Printf("%s %s \u2192 %s (listing)", request.Method, request.URL.Path, dir) }
+  traceForRequest(request).setResource(dir)
+  
+  writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+  if err := t.Execute(writer, map[string]interface{}{"Path": request.URL.Path, "Parent": request.URL.Path != "/", "Entries": entries}); err != nil {
+    log.Printf("ERROR: Could not render listing: %v\n", err)
+  }
+  
+  return true
+}
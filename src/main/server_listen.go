@@ -0,0 +1,171 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "os"
+  "fmt"
+  "net"
+  "strconv"
+  "strings"
+  "syscall"
+)
+
+const (
+  listenUnixPrefix    = "unix:"
+  listenFDsStart      = 3 // the first file descriptor passed via socket activation
+)
+
+/**
+ * Open listeners for the specified addresses. Addresses are either TCP addresses in
+ * the form '<host>:<port>' or Unix domain sockets in the form 'unix:<path>'. If the
+ * process was started via systemd-style socket activation, the sockets passed to us
+ * are used instead.
+ */
+func listen(addresses []string) ([]net.Listener, error) {
+  
+  if listeners, err := activatedListeners(); err != nil {
+    return nil, err
+  }else if len(listeners) > 0 {
+    return listeners, nil
+  }
+  
+  listeners := make([]net.Listener, 0, len(addresses))
+  for _, e := range addresses {
+    if l, err := listenAddress(e); err != nil {
+      for _, c := range listeners { c.Close() }
+      return nil, err
+    }else{
+      listeners = append(listeners, l)
+    }
+  }
+  
+  return listeners, nil
+}
+
+/**
+ * Open a listener for an address
+ */
+func listenAddress(address string) (net.Listener, error) {
+  if strings.HasPrefix(address, listenUnixPrefix) {
+    socket := address[len(listenUnixPrefix):]
+    // remove a stale socket left behind by a previous run
+    if info, err := os.Stat(socket); err == nil && info.Mode() & os.ModeSocket != 0 {
+      if c, err := net.Dial("unix", socket); err == nil {
+        c.Close()
+        return nil, fmt.Errorf("Socket is already in use: %s", socket)
+      }else if err := os.Remove(socket); err != nil {
+        return nil, err
+      }
+    }
+    return net.Listen("unix", socket)
+  }else{
+    return net.Listen("tcp", address)
+  }
+}
+
+/**
+ * Obtain listeners passed to us via systemd-style socket activation. If we weren't
+ * socket activated, no listeners are returned.
+ */
+func activatedListeners() ([]net.Listener, error) {
+  
+  if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+    return nil, nil
+  }
+  
+  n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+  if err != nil || n < 1 {
+    return nil, nil
+  }
+  
+  // don't pass these along to any children
+  os.Unsetenv("LISTEN_PID")
+  os.Unsetenv("LISTEN_FDS")
+  os.Unsetenv("LISTEN_FDNAMES")
+  
+  listeners := make([]net.Listener, n)
+  for i := 0; i < n; i++ {
+    fd := listenFDsStart + i
+    syscall.CloseOnExec(fd)
+    file := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+    l, err := net.FileListener(file)
+    file.Close()
+    if err != nil {
+      return nil, fmt.Errorf("Could not use activated socket %d: %v", fd, err)
+    }
+    listeners[i] = l
+  }
+  
+  return listeners, nil
+}
+
+/**
+ * Obtain the port of the first TCP listener, if there is one
+ */
+func listenerPort(listeners []net.Listener) (int, bool) {
+  for _, e := range listeners {
+    if a, ok := e.Addr().(*net.TCPAddr); ok {
+      return a.Port, true
+    }
+  }
+  return 0, false
+}
+
+/**
+ * Obtain the addresses to listen on for the specified port on every host a TCP
+ * listener is bound to
+ */
+func redirectAddresses(listeners []net.Listener, port int) []string {
+  var addresses []string
+  seen := make(map[string]bool)
+  for _, e := range listeners {
+    if a, ok := e.Addr().(*net.TCPAddr); ok {
+      address := net.JoinHostPort(a.IP.String(), strconv.Itoa(port))
+      if !seen[address] {
+        seen[address] = true
+        addresses = append(addresses, address)
+      }
+    }
+  }
+  return addresses
+}
+
+/**
+ * Describe the address a listener is bound to
+ */
+func listenerAddress(l net.Listener) string {
+  if a := l.Addr(); a.Network() == "unix" {
+    return listenUnixPrefix + a.String()
+  }else{
+    return a.String()
+  }
+}
@@ -0,0 +1,249 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "os"
+  "io"
+  "fmt"
+  "log"
+  "path"
+  "time"
+  "strings"
+  "io/ioutil"
+  "path/filepath"
+  "text/template"
+)
+
+import (
+  "net/http"
+)
+
+/**
+ * A mock endpoint
+ */
+type mockRoute struct {
+  method    string
+  segments  []string
+  options   MockOptions
+}
+
+/**
+ * Create mock endpoints from their definitions
+ */
+func newMockRoutes(defs []MockOptions) ([]*mockRoute, error) {
+  mocks := make([]*mockRoute, len(defs))
+  
+  for i, e := range defs {
+    if e.Path == "" || e.Path[0] != '/' {
+      return nil, fmt.Errorf("Mock path must be absolute: '%s'", e.Path)
+    }else if e.File == "" && e.Body == "" && e.Status == 0 {
+      return nil, fmt.Errorf("Mock must define a file, a body, or a status: %s", e.Path)
+    }
+    mocks[i] = &mockRoute{strings.ToUpper(e.Method), strings.Split(e.Path[1:], "/"), e}
+  }
+  
+  return mocks, nil
+}
+
+/**
+ * Match a request against this endpoint. If the request matches, the path parameters
+ * captured by the endpoint's pattern are returned. Path segments of the form ':name'
+ * capture a single segment and a final '*' segment captures the remainder of the path.
+ */
+func (m *mockRoute) match(request *http.Request) (map[string]string, bool) {
+  
+  if m.method != "" && m.method != "*" && m.method != request.Method {
+    return nil, false
+  }
+  
+  segments := strings.Split(strings.TrimPrefix(request.URL.Path, "/"), "/")
+  params := make(map[string]string)
+  
+  for i, e := range m.segments {
+    if e == "*" && i == len(m.segments) - 1 {
+      params["*"] = strings.Join(segments[i:], "/")
+      return params, true
+    }else if i >= len(segments) {
+      return nil, false
+    }else if strings.HasPrefix(e, ":") {
+      params[e[1:]] = segments[i]
+    }else if e != segments[i] {
+      return nil, false
+    }
+  }
+  
+  if len(segments) != len(m.segments) {
+    return nil, false
+  }
+  
+  return params, true
+}
+
+/**
+ * Find the first mock endpoint matching a request
+ */
+func matchMockRoute(mocks []*mockRoute, request *http.Request) (*mockRoute, map[string]string) {
+  for _, e := range mocks {
+    if params, ok := e.match(request); ok {
+      return e, params
+    }
+  }
+  return nil, nil
+}
+
+/**
+ * Serve a mock endpoint
+ */
+func (s *Server) serveMock(writer http.ResponseWriter, request *http.Request, mock *mockRoute, params map[string]string) {
+  var input io.Reader
+  options := mock.options
+  
+  if !s.options.GetFlag(OptionsFlagQuiet) { log.Printf("%s %s \u2192 (mock) %s", request.Method, request.URL.Path, options.Path) }
+  if options.Latency > 0 {
+    time.Sleep(options.Latency)
+  }
+  
+  status := options.Status
+  if status == 0 {
+    status = http.StatusOK
+  }
+  
+  mimetype := "text/plain"
+  
+  if options.File != "" {
+    resource := options.File
+    if !filepath.IsAbs(resource) {
+      resource = path.Join(s.root, resource)
+    }
+    file, err := os.Open(resource)
+    if err != nil {
+      s.serveError(writer, request, http.StatusInternalServerError, fmt.Errorf("Could not open mock: %v", err))
+      return
+    }else{
+      defer file.Close()
+    }
+    if m, ok := MIMETYPES[mockFixtureExt(resource)]; ok {
+      mimetype = m
+    }
+    traceForRequest(request).setResource(resource)
+    input = file
+  }else{
+    input = strings.NewReader(options.Body)
+  }
+  
+  header := writer.Header()
+  header.Set("Content-Type", mimetype)
+  for k, v := range options.Headers {
+    header.Set(k, v)
+  }
+  
+  // templates are rendered before we respond so that we can report errors
+  if path.Ext(options.File) == ".ghtml" {
+    rendered := &strings.Builder{}
+    if err := renderMock(options.File, input, rendered, mockVariables(s.options, request, params)); err != nil {
+      s.serveError(writer, request, http.StatusInternalServerError, err)
+      return
+    }
+    input = strings.NewReader(rendered.String())
+  }
+  
+  traceForRequest(request).setSource(TraceSourceMock)
+  writer.WriteHeader(status)
+  io.Copy(writer, input)
+  
+}
+
+/**
+ * Obtain the extension that determines how a mock fixture is served. A template is
+ * served as the type of its inner extension, like 'fixture.json.ghtml', or as HTML
+ * when it has none.
+ */
+func mockFixtureExt(name string) string {
+  ext := path.Ext(name)
+  if ext != ".ghtml" {
+    return ext
+  }
+  if inner := path.Ext(strings.TrimSuffix(name, ext)); inner != "" {
+    return inner
+  }
+  return ".html"
+}
+
+/**
+ * Render a mock template. Only HTML fixtures are escaped as HTML; anything else, like
+ * JSON, is rendered as plain text.
+ */
+func renderMock(name string, input io.Reader, output io.Writer, variables map[string]interface{}) error {
+  if mockFixtureExt(name) == ".html" {
+    return (&TemplateCompiler{}).Compile(NewContextWithVariables(variables), name, "", input, output)
+  }
+  
+  serial, err := ioutil.ReadAll(input)
+  if err != nil {
+    return fmt.Errorf("Could not read template: %v\n", err)
+  }
+  
+  t, err := template.New(name).Parse(string(serial))
+  if err != nil {
+    return fmt.Errorf("Could not parse template: %v\n", err)
+  }
+  
+  if err := t.Execute(output, variables); err != nil {
+    return fmt.Errorf("Could not execute template: %v\n", err)
+  }
+  
+  return nil
+}
+
+/**
+ * Obtain the variables available to a mock template. These are the configured variables
+ * plus 'Params', the captured path parameters, and 'Query', the query parameters.
+ */
+func mockVariables(options *Options, request *http.Request, params map[string]string) map[string]interface{} {
+  vars := make(map[string]interface{})
+  
+  for k, v := range options.Variables {
+    vars[k] = v
+  }
+  
+  query := make(map[string]string)
+  for k, v := range request.URL.Query() {
+    if len(v) > 0 {
+      query[k] = v[len(v) - 1]
+    }
+  }
+  
+  vars["Params"] = params
+  vars["Query"] = query
+  
+  return vars
+}
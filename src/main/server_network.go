@@ -0,0 +1,397 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "fmt"
+  "sync"
+  "time"
+  "strings"
+  "reflect"
+  "math/rand"
+)
+
+import (
+  "net/http"
+  "encoding/json"
+)
+
+const (
+  NETWORK_ENDPOINT  = SLANG_PREFIX +"network"
+)
+
+/**
+ * Network conditions
+ */
+type NetworkConditions struct {
+  Latency       time.Duration
+  Jitter        time.Duration
+  Bandwidth     int
+  FailureRate   float64
+  FailureStatus []int
+}
+
+/**
+ * Network conditions as represented in JSON
+ */
+type networkConditionsJSON struct {
+  Latency       string          `json:"latency"`
+  Jitter        string          `json:"jitter"`
+  Bandwidth     int             `json:"bandwidth"`
+  FailureRate   float64         `json:"failure_rate"`
+  FailureStatus []int           `json:"failure_status"`
+}
+
+/**
+ * Marshal network conditions
+ */
+func (c NetworkConditions) MarshalJSON() ([]byte, error) {
+  return json.Marshal(c.jsonValue())
+}
+
+/**
+ * Obtain the JSON representation of network conditions
+ */
+func (c NetworkConditions) jsonValue() networkConditionsJSON {
+  return networkConditionsJSON{c.Latency.String(), c.Jitter.String(), c.Bandwidth, c.FailureRate, c.FailureStatus}
+}
+
+/**
+ * Unmarshal network conditions
+ */
+func (c *NetworkConditions) UnmarshalJSON(data []byte) error {
+  var j networkConditionsJSON
+  var err error
+  
+  if err := json.Unmarshal(data, &j); err != nil {
+    return err
+  }
+  
+  conditions := NetworkConditions{Bandwidth: j.Bandwidth, FailureRate: j.FailureRate, FailureStatus: j.FailureStatus}
+  if j.Latency != "" {
+    if conditions.Latency, err = time.ParseDuration(j.Latency); err != nil {
+      return fmt.Errorf("Latency is not valid: %v", err)
+    }
+  }
+  if j.Jitter != "" {
+    if conditions.Jitter, err = time.ParseDuration(j.Jitter); err != nil {
+      return fmt.Errorf("Jitter is not valid: %v", err)
+    }
+  }
+  
+  *c = conditions
+  return nil
+}
+
+/**
+ * Determine whether any conditions are actually being simulated
+ */
+func (c NetworkConditions) IsZero() bool {
+  return c.Latency <= 0 && c.Jitter <= 0 && c.Bandwidth <= 0 && c.FailureRate <= 0
+}
+
+/**
+ * Make sure conditions can actually be simulated
+ */
+func (c NetworkConditions) Validate() error {
+  if c.Latency < 0 || c.Jitter < 0 {
+    return fmt.Errorf("Network latency and jitter must not be negative")
+  }
+  if c.Bandwidth < 0 {
+    return fmt.Errorf("Network bandwidth must not be negative")
+  }
+  if c.FailureRate < 0 || c.FailureRate > 1 {
+    return fmt.Errorf("Network failure rate must be between 0 and 1")
+  }
+  for _, e := range c.FailureStatus {
+    if e < 100 || e > 599 {
+      return fmt.Errorf("Network failure status is not valid: %d", e)
+    }
+  }
+  return nil
+}
+
+/**
+ * Network conditions that apply to routes under a path prefix
+ */
+type NetworkRoute struct {
+  Path          string              `json:"path"`
+  Conditions    NetworkConditions   `json:"conditions"`
+}
+
+/**
+ * Marshal network options. Without this the embedded conditions' marshaler would be
+ * used for the whole struct and the routes would be dropped.
+ */
+func (n NetworkOptions) MarshalJSON() ([]byte, error) {
+  return json.Marshal(struct {
+    networkConditionsJSON
+    Routes      []NetworkRoute    `json:"routes"`
+  }{n.NetworkConditions.jsonValue(), n.Routes})
+}
+
+/**
+ * A network simulator. This applies simulated network conditions to every request the
+ * server handles, whether it is compiled locally or proxied.
+ */
+type networkSimulator struct {
+  sync.RWMutex
+  defaults    NetworkConditions
+  global      NetworkConditions
+  configured  []NetworkRoute
+  routes      []NetworkRoute
+  fail        func(http.ResponseWriter, *http.Request, int, error)
+}
+
+/**
+ * Create a network simulator
+ */
+func newNetworkSimulator(options NetworkOptions, fail func(http.ResponseWriter, *http.Request, int, error)) *networkSimulator {
+  return &networkSimulator{defaults: options.NetworkConditions, global: options.NetworkConditions, configured: options.Routes, routes: copyNetworkRoutes(options.Routes), fail: fail}
+}
+
+/**
+ * Copy network routes, so that changing them at runtime doesn't change our options
+ */
+func copyNetworkRoutes(routes []NetworkRoute) []NetworkRoute {
+  return append([]NetworkRoute(nil), routes...)
+}
+
+/**
+ * Inherit the conditions set at runtime on another simulator, provided the configured
+ * conditions have not changed
+ */
+func (n *networkSimulator) inherit(p *networkSimulator) {
+  p.RLock()
+  defer p.RUnlock()
+  if reflect.DeepEqual(n.defaults, p.defaults) && reflect.DeepEqual(n.configured, p.configured) {
+    n.Lock()
+    n.global = p.global
+    n.routes = copyNetworkRoutes(p.routes)
+    n.Unlock()
+  }
+}
+
+/**
+ * Obtain the conditions that apply to a request
+ */
+func (n *networkSimulator) conditions(request *http.Request) NetworkConditions {
+  n.RLock()
+  defer n.RUnlock()
+  for _, e := range n.routes {
+    if strings.HasPrefix(request.URL.Path, e.Path) {
+      return e.Conditions
+    }
+  }
+  return n.global
+}
+
+/**
+ * Wrap a handler so that the requests it handles are subject to simulated conditions
+ */
+func (n *networkSimulator) wrap(handler http.Handler) http.Handler {
+  return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+    
+    // our own endpoints are never subject to simulation
+    if strings.HasPrefix(request.URL.Path, SLANG_PREFIX) {
+      handler.ServeHTTP(writer, request)
+      return
+    }
+    
+    conditions := n.conditions(request)
+    if conditions.IsZero() {
+      handler.ServeHTTP(writer, request)
+      return
+    }
+    
+    delay := conditions.Latency
+    if conditions.Jitter > 0 {
+      delay += time.Duration(rand.Int63n(int64(conditions.Jitter)))
+    }
+    if delay > 0 {
+      time.Sleep(delay)
+    }
+    
+    if conditions.FailureRate > 0 && rand.Float64() < conditions.FailureRate {
+      status := http.StatusServiceUnavailable
+      if l := len(conditions.FailureStatus); l > 0 {
+        status = conditions.FailureStatus[rand.Intn(l)]
+      }
+      n.fail(writer, request, status, fmt.Errorf("Simulated failure: %s", request.URL.Path))
+      return
+    }
+    
+    if conditions.Bandwidth > 0 {
+      writer = &throttledWriter{writer, conditions.Bandwidth}
+    }
+    
+    handler.ServeHTTP(writer, request)
+  })
+}
+
+/**
+ * Set the conditions for requests under a path prefix. A path which doesn't have
+ * conditions yet takes precedence over any shorter prefix of it.
+ */
+func (n *networkSimulator) setRoute(prefix string, conditions NetworkConditions) {
+  for i, e := range n.routes {
+    if e.Path == prefix {
+      n.routes[i].Conditions = conditions
+      return
+    }
+  }
+  for i, e := range n.routes {
+    if strings.HasPrefix(prefix, e.Path) {
+      n.routes = append(n.routes[:i], append([]NetworkRoute{{prefix, conditions}}, n.routes[i:]...)...)
+      return
+    }
+  }
+  n.routes = append(n.routes, NetworkRoute{prefix, conditions})
+}
+
+/**
+ * Restore the configured conditions for requests under a path prefix, removing the
+ * path altogether if it wasn't configured
+ */
+func (n *networkSimulator) resetRoute(prefix string) {
+  for i, e := range n.routes {
+    if e.Path != prefix {
+      continue
+    }
+    for _, c := range n.configured {
+      if c.Path == prefix {
+        n.routes[i].Conditions = c.Conditions
+        return
+      }
+    }
+    n.routes = append(n.routes[:i], n.routes[i+1:]...)
+    return
+  }
+}
+
+/**
+ * Serve the network control endpoint. GET reports the current conditions, PUT or POST
+ * replaces the global conditions with those in the request body, and DELETE restores
+ * all the configured conditions. With a 'path' parameter, PUT, POST and DELETE apply
+ * to the conditions for requests under that path instead.
+ */
+func (n *networkSimulator) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+  prefix := request.URL.Query().Get("path")
+  
+  switch request.Method {
+    
+    case "GET", "HEAD":
+      // just report below
+      
+    case "PUT", "POST":
+      var conditions NetworkConditions
+      if err := json.NewDecoder(request.Body).Decode(&conditions); err != nil {
+        http.Error(writer, fmt.Sprintf("Network conditions are not valid: %v", err), http.StatusBadRequest)
+        return
+      }else if err := conditions.Validate(); err != nil {
+        http.Error(writer, err.Error(), http.StatusBadRequest)
+        return
+      }
+      n.Lock()
+      if prefix != "" {
+        n.setRoute(prefix, conditions)
+      }else{
+        n.global = conditions
+      }
+      n.Unlock()
+      
+    case "DELETE":
+      n.Lock()
+      if prefix != "" {
+        n.resetRoute(prefix)
+      }else{
+        n.global = n.defaults
+        n.routes = copyNetworkRoutes(n.configured)
+      }
+      n.Unlock()
+      
+    default:
+      http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+      return
+      
+  }
+  
+  n.RLock()
+  defer n.RUnlock()
+  writer.Header().Set("Content-Type", "application/json")
+  json.NewEncoder(writer).Encode(map[string]interface{}{"global": n.global, "routes": n.routes})
+}
+
+/**
+ * A response writer which limits the rate at which data is written
+ */
+type throttledWriter struct {
+  http.ResponseWriter
+  bandwidth int // bytes per second
+}
+
+/**
+ * Write data, no faster than our bandwidth permits
+ */
+func (w *throttledWriter) Write(b []byte) (int, error) {
+  chunk := w.bandwidth / 10
+  if chunk < 1 {
+    chunk = 1
+  }
+  
+  var n int
+  for n < len(b) {
+    l := len(b) - n
+    if l > chunk {
+      l = chunk
+    }
+    c, err := w.ResponseWriter.Write(b[n:n+l])
+    n += c
+    if err != nil {
+      return n, err
+    }
+    if f, ok := w.ResponseWriter.(http.Flusher); ok {
+      f.Flush()
+    }
+    time.Sleep(time.Duration(l) * time.Second / time.Duration(w.bandwidth))
+  }
+  
+  return n, nil
+}
+
+/**
+ * Flush the response, if the underlying writer supports it
+ */
+func (w *throttledWriter) Flush() {
+  if f, ok := w.ResponseWriter.(http.Flusher); ok {
+    f.Flush()
+  }
+}
@@ -0,0 +1,217 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "fmt"
+  "log"
+  "path"
+  "strconv"
+  "strings"
+  "encoding/json"
+  "text/template"
+)
+
+import (
+  "net/http"
+)
+
+/**
+ * Error presentation formats
+ */
+const (
+  ERROR_FORMAT_HTML = "html"
+  ERROR_FORMAT_CSS  = "css"
+  ERROR_FORMAT_JS   = "js"
+  ERROR_FORMAT_JSON = "json"
+)
+
+/**
+ * Determine how an error should be presented for a request. Browsers which
+ * identify the destination of a request are taken at their word; otherwise the
+ * path is consulted.
+ */
+func errorFormat(request *http.Request) string {
+  switch request.Header.Get("Sec-Fetch-Dest") {
+    case "style":
+      return ERROR_FORMAT_CSS
+    case "script":
+      return ERROR_FORMAT_JS
+    case "", "empty":
+      // not conclusive
+    default:
+      return ERROR_FORMAT_HTML
+  }
+  switch path.Ext(request.URL.Path) {
+    case ".css":
+      return ERROR_FORMAT_CSS
+    case ".js":
+      return ERROR_FORMAT_JS
+    default:
+      return ERROR_FORMAT_HTML
+  }
+}
+
+/**
+ * Prepare the headers for an error which is presented in the page. The response
+ * must be successful or the browser will discard it, so the actual status is
+ * provided separately.
+ */
+func prepareOverlay(writer http.ResponseWriter, mimetype string, status int) {
+  writer.Header().Set("Content-Type", mimetype)
+  writer.Header().Set("Cache-Control", "no-store")
+  writer.Header().Set("X-Slang-Error", fmt.Sprintf("%d", status))
+  writer.WriteHeader(http.StatusOK)
+}
+
+/**
+ * Serve an error for a stylesheet as a stylesheet which displays it in a banner
+ */
+func (s *Server) serveStylesheetError(writer http.ResponseWriter, request *http.Request, status int, problem error) {
+  message, issues := errorIssues(problem)
+  
+  text := fmt.Sprintf("%d: %s\n%s", status, request.URL.Path, message)
+  for _, e := range issues {
+    text += "\n\n"+ e.Message
+    if len(e.Text) > 0 {
+      text += "\n\n"+ strings.Join(e.Text, "\n")
+    }
+  }
+  
+  t, err := template.ParseFiles(s.options.Resource("html/error.css"))
+  if err != nil {
+    log.Printf("ERROR: Could not compile template: %v\n", err)
+    return
+  }
+  
+  prepareOverlay(writer, "text/css", status)
+  t.Execute(writer, map[string]interface{}{"Content": cssString(text), "Comment": strings.Replace(text, "*/", "* /", -1)})
+}
+
+/**
+ * Serve an error for a script as a script which displays it in an overlay
+ */
+func (s *Server) serveScriptError(writer http.ResponseWriter, request *http.Request, status int, problem error) {
+  message, issues := errorIssues(problem)
+  
+  report, err := json.Marshal(map[string]interface{}{
+    "header":   fmt.Sprintf("%d: %s", status, http.StatusText(status)),
+    "resource": request.URL.Path,
+    "message":  message,
+    "errors":   issues,
+  })
+  if err != nil {
+    log.Printf("ERROR: Could not encode error: %v\n", err)
+    return
+  }
+  
+  t, err := template.ParseFiles(s.options.Resource("html/error.js"))
+  if err != nil {
+    log.Printf("ERROR: Could not compile template: %v\n", err)
+    return
+  }
+  
+  prepareOverlay(writer, "application/javascript", status)
+  t.Execute(writer, map[string]interface{}{"Report": string(report)})
+}
+
+/**
+ * Serve an error as a JSON diagnostic
+ */
+func (s *Server) serveDiagnosticError(writer http.ResponseWriter, request *http.Request, status int, problem error) {
+  data, err := json.MarshalIndent(map[string]interface{}{
+    "status":       status,
+    "path":         request.URL.Path,
+    "diagnostics":  diagnosticsForError(SeverityError, traceForRequest(request).resource(), problem),
+  }, "", "  ")
+  if err != nil {
+    log.Printf("ERROR: Could not encode error: %v\n", err)
+    return
+  }
+  
+  writer.Header().Set("Content-Type", "application/json")
+  writer.WriteHeader(status)
+  writer.Write(data)
+}
+
+/**
+ * Determine whether an Accept header prefers JSON to HTML
+ */
+func prefersJSON(accept string) bool {
+  var jsonQ, htmlQ float64
+  var jsonIndex, htmlIndex int = -1, -1
+  
+  for i, e := range strings.Split(accept, ",") {
+    params := strings.Split(e, ";")
+    mimetype := strings.ToLower(strings.TrimSpace(params[0]))
+    q := 1.0
+    for _, p := range params[1:] {
+      if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+        if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+          q = v
+        }
+      }
+    }
+    if mimetype == "application/json" || strings.HasSuffix(mimetype, "+json") {
+      if q > jsonQ { jsonQ, jsonIndex = q, i }
+    }else if mimetype == "text/html" {
+      if q > htmlQ { htmlQ, htmlIndex = q, i }
+    }
+  }
+  
+  if jsonQ <= 0 {
+    return false
+  }else if jsonQ != htmlQ {
+    return jsonQ > htmlQ
+  }else{
+    return jsonIndex < htmlIndex
+  }
+}
+
+/**
+ * Quote text as a CSS string
+ */
+func cssString(text string) string {
+  quoted := `"`
+  for _, c := range text {
+    switch {
+      case c == '"' || c == '\\':
+        quoted += `\`+ string(c)
+      case c == '\n':
+        quoted += `\A `
+      case c < 0x20 || c == 0x7f || c == '<' || c == '>':
+        quoted += fmt.Sprintf(`\%x `, c)
+      default:
+        quoted += string(c)
+    }
+  }
+  return quoted +`"`
+}
@@ -29,7 +29,8 @@
 //
 // Based on the Go net/http/httputil/reverseproxy.go implementation. This implementation
 // is identical except that errors are propagated to the caller instead of being handled
-// via an HTTP response.
+// via an HTTP response and responses may be modified before they are written back to
+// the client.
 // 
 // Orignal source:
 // 
@@ -78,6 +79,12 @@ type ReverseProxy struct {
 	// response body.
 	// If zero, no periodic flushing is done.
 	FlushInterval time.Duration
+
+	// ModifyResponse is an optional function that modifies the
+	// response from the backend before it is copied back to the
+	// client. It is provided the original inbound request. If it
+	// returns an error the error is propagated to the caller.
+	ModifyResponse func(*http.Request, *http.Response) error
 }
 
 func singleJoiningSlash(a, b string) string {
@@ -97,20 +104,32 @@ func singleJoiningSlash(a, b string) string {
 // target's path is "/base" and the incoming request was for "/dir",
 // the target request will be for /base/dir.
 func NewSingleHostReverseProxy(target *url.URL) *ReverseProxy {
-	targetQuery := target.RawQuery
 	director := func(req *http.Request) {
-		req.URL.Scheme = target.Scheme
-		req.URL.Host = target.Host
-		req.URL.Path = singleJoiningSlash(target.Path, req.URL.Path)
-		if targetQuery == "" || req.URL.RawQuery == "" {
-			req.URL.RawQuery = targetQuery + req.URL.RawQuery
-		} else {
-			req.URL.RawQuery = targetQuery + "&" + req.URL.RawQuery
-		}
+		proxyTarget(target, req.URL)
 	}
 	return &ReverseProxy{Director: director}
 }
 
+// proxyTarget rewrites u in place to refer to the same resource under the
+// scheme, host, and base path provided in target.
+func proxyTarget(target, u *url.URL) {
+	u.Scheme = target.Scheme
+	u.Host = target.Host
+	u.Path = singleJoiningSlash(target.Path, u.Path)
+	if target.RawQuery == "" || u.RawQuery == "" {
+		u.RawQuery = target.RawQuery + u.RawQuery
+	} else {
+		u.RawQuery = target.RawQuery + "&" + u.RawQuery
+	}
+}
+
+// proxyURL returns the URL a request for u is proxied to.
+func proxyURL(target, u *url.URL) *url.URL {
+	p := &url.URL{Path: u.Path, RawQuery: u.RawQuery}
+	proxyTarget(target, p)
+	return p
+}
+
 func copyHeader(dst, src http.Header) {
 	for k, vv := range src {
 		for _, v := range vv {
@@ -141,6 +160,10 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) erro
 	outreq := new(http.Request)
 	*outreq = *req // includes shallow copies of maps, but okay
 	
+	// the director rewrites the URL, which must not change the inbound request
+	u := *req.URL
+	outreq.URL = &u
+	
 	p.Director(outreq)
 	outreq.Proto = "HTTP/1.1"
 	outreq.ProtoMajor = 1
@@ -192,6 +215,12 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) erro
 	  return FileNotFoundError
 	}
 	
+	if p.ModifyResponse != nil {
+		if err := p.ModifyResponse(req, res); err != nil {
+			return err
+		}
+	}
+	
 	copyHeader(rw.Header(), res.Header)
 	rw.WriteHeader(res.StatusCode)
 	p.copyResponse(rw, res.Body)
@@ -0,0 +1,262 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "os"
+  "fmt"
+  "log"
+  "bytes"
+  "strings"
+  "io/ioutil"
+  "path/filepath"
+)
+
+import (
+  "net/http"
+  "crypto/sha1"
+  "encoding/hex"
+  "encoding/json"
+)
+
+const (
+  ProxyCacheModeNone      = ""
+  ProxyCacheModeRecord    = "record"
+  ProxyCacheModeReplay    = "replay"
+  ProxyCacheModeFallback  = "fallback"
+)
+
+const (
+  PROXY_CACHE_PATH_DEFAULT = "./.slang/cache/proxy"
+)
+
+/**
+ * A recorded response
+ */
+type proxyCacheEntry struct {
+  Method    string                  `json:"method"`
+  URL       string                  `json:"url"`
+  Status    int                     `json:"status"`
+  Header    http.Header             `json:"header"`
+  Body      []byte                  `json:"body"`
+}
+
+/**
+ * A proxy cache transport. This records responses from the proxied peer to disk and
+ * replays them, depending on its mode:
+ * 
+ *   record    - every response is fetched from the peer and recorded;
+ *   replay    - responses are only served from the cache, the peer is never contacted;
+ *   fallback  - responses are fetched from the peer and recorded, but when the peer
+ *               cannot be reached or responds with a server error the recorded
+ *               response is used instead.
+ * 
+ * Server errors are never recorded, so that they don't replace a good response.
+ */
+type proxyCacheTransport struct {
+  mode      string
+  root      string
+  headers   []string
+  transport http.RoundTripper
+}
+
+/**
+ * A request for which no response has been recorded, when replaying
+ */
+type proxyCacheMissError struct {
+  method    string
+  url       string
+}
+
+/**
+ * Describe the error
+ */
+func (e *proxyCacheMissError) Error() string {
+  return fmt.Sprintf("No response has been recorded for: %s %s; the proxied server is never contacted when replaying", e.method, e.url)
+}
+
+/**
+ * Create a proxy cache transport
+ */
+func newProxyCacheTransport(mode, root string, headers []string, transport http.RoundTripper) (*proxyCacheTransport, error) {
+  switch mode {
+    case ProxyCacheModeRecord, ProxyCacheModeReplay, ProxyCacheModeFallback:
+      // ok
+    default:
+      return nil, fmt.Errorf("Proxy cache mode is not supported: %s", mode)
+  }
+  if root == "" {
+    root = PROXY_CACHE_PATH_DEFAULT
+  }
+  if transport == nil {
+    transport = http.DefaultTransport
+  }
+  return &proxyCacheTransport{mode, root, headers, transport}, nil
+}
+
+/**
+ * Perform a round trip
+ */
+func (t *proxyCacheTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+  switch t.mode {
+    
+    case ProxyCacheModeReplay:
+      if entry, err := t.load(request); err != nil {
+        return nil, err
+      }else if entry == nil {
+        return nil, &proxyCacheMissError{request.Method, request.URL.String()}
+      }else{
+        return entry.response(request), nil
+      }
+      
+    case ProxyCacheModeFallback:
+      response, err := t.record(request)
+      if err == nil && response.StatusCode < 500 {
+        return response, nil
+      }
+      if entry, cerr := t.load(request); cerr != nil || entry == nil {
+        return response, err // nothing better to offer
+      }else{
+        if err == nil {
+          err = fmt.Errorf("Proxied server responded: %s", response.Status)
+        }
+        if SharedOptions().GetFlag(OptionsFlagVerbose) { log.Printf("%s %s \u2192 (recorded) %v", request.Method, request.URL, err) }
+        return entry.response(request), nil
+      }
+      
+    default:
+      return t.record(request)
+      
+  }
+}
+
+/**
+ * Perform a round trip and record the response
+ */
+func (t *proxyCacheTransport) record(request *http.Request) (*http.Response, error) {
+  
+  response, err := t.transport.RoundTrip(request)
+  if err != nil {
+    return nil, err
+  }else{
+    defer response.Body.Close()
+  }
+  
+  body, err := ioutil.ReadAll(response.Body)
+  if err != nil {
+    return nil, err
+  }
+  
+  // server errors are passed along, but they don't replace a good response
+  if response.StatusCode < 500 {
+    entry := &proxyCacheEntry{request.Method, request.URL.String(), response.StatusCode, response.Header, body}
+    if err := t.store(request, entry); err != nil {
+      log.Printf("ERROR: Could not record response: %s %s: %v", request.Method, request.URL, err)
+    }
+  }
+  
+  response.Body = ioutil.NopCloser(bytes.NewReader(body))
+  return response, nil
+}
+
+/**
+ * Obtain the cache path for a request. Requests are keyed by method, URL and the
+ * values of any headers the cache is configured to consider.
+ */
+func (t *proxyCacheTransport) path(request *http.Request) string {
+  key := request.Method +" "+ request.URL.String()
+  for _, e := range t.headers {
+    key += "\n"+ strings.ToLower(e) +": "+ strings.Join(request.Header[http.CanonicalHeaderKey(e)], ", ")
+  }
+  sum := sha1.Sum([]byte(key))
+  return filepath.Join(t.root, hex.EncodeToString(sum[:]) +".json")
+}
+
+/**
+ * Load a recorded response. If no response has been recorded, nil is returned.
+ */
+func (t *proxyCacheTransport) load(request *http.Request) (*proxyCacheEntry, error) {
+  
+  file, err := os.Open(t.path(request))
+  if os.IsNotExist(err) {
+    return nil, nil
+  }else if err != nil {
+    return nil, err
+  }else{
+    defer file.Close()
+  }
+  
+  entry := &proxyCacheEntry{}
+  if err := json.NewDecoder(file).Decode(entry); err != nil {
+    return nil, fmt.Errorf("Recorded response is not valid: %s: %v", file.Name(), err)
+  }
+  
+  return entry, nil
+}
+
+/**
+ * Record a response
+ */
+func (t *proxyCacheTransport) store(request *http.Request, entry *proxyCacheEntry) error {
+  
+  if err := os.MkdirAll(t.root, 0755); err != nil {
+    return err
+  }
+  
+  file, err := os.OpenFile(t.path(request), os.O_WRONLY | os.O_CREATE | os.O_TRUNC, 0644)
+  if err != nil {
+    return err
+  }else{
+    defer file.Close()
+  }
+  
+  return json.NewEncoder(file).Encode(entry)
+}
+
+/**
+ * Produce a response from a recorded entry
+ */
+func (e *proxyCacheEntry) response(request *http.Request) *http.Response {
+  header := make(http.Header)
+  copyHeader(header, e.Header)
+  header.Set("X-Slang-Cache", "replay")
+  return &http.Response{
+    Status: fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+    StatusCode: e.Status,
+    Proto: "HTTP/1.1",
+    ProtoMajor: 1,
+    ProtoMinor: 1,
+    Header: header,
+    Body: ioutil.NopCloser(bytes.NewReader(e.Body)),
+    ContentLength: int64(len(e.Body)),
+    Request: request,
+  }
+}
@@ -0,0 +1,216 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "strings"
+)
+
+import (
+  "net/url"
+  "net/http"
+)
+
+/**
+ * Headers which contain a URL that may refer to the proxied peer
+ */
+var locationHeaders = []string{
+  "Location",
+  "Content-Location",
+}
+
+/**
+ * A proxy response rewriter. This rewrites responses from the proxied peer so that
+ * they refer to the Slang server instead of the peer, which keeps the browser on our
+ * origin when the peer redirects or sets cookies.
+ */
+type proxyRewriter struct {
+  peer      *url.URL
+  options   ProxyOptions
+}
+
+/**
+ * Create a proxy response rewriter
+ */
+func newProxyRewriter(peer *url.URL, options ProxyOptions) *proxyRewriter {
+  return &proxyRewriter{peer, options}
+}
+
+/**
+ * Rewrite a proxied response. The request is the original inbound request, not the
+ * request that was sent to the peer.
+ */
+func (r *proxyRewriter) rewriteResponse(request *http.Request, response *http.Response) error {
+  origin := requestOrigin(request)
+  
+  if r.options.RewriteLocation {
+    for _, h := range locationHeaders {
+      if v := response.Header.Get(h); v != "" {
+        response.Header.Set(h, r.rewriteURL(origin, v))
+      }
+    }
+    if v := response.Header.Get("Refresh"); v != "" {
+      response.Header.Set("Refresh", r.rewriteRefresh(origin, v))
+    }
+  }
+  
+  if r.options.RewriteCookies {
+    if cookies := response.Header["Set-Cookie"]; len(cookies) > 0 {
+      rewritten := make([]string, len(cookies))
+      for i, e := range cookies {
+        rewritten[i] = rewriteCookie(e, origin.Scheme == "https")
+      }
+      response.Header["Set-Cookie"] = rewritten
+    }
+  }
+  
+  if r.options.CORS {
+    addCORSHeaders(request, response.Header, r.options.CORSOrigin)
+  }
+  
+  return nil
+}
+
+/**
+ * Rewrite a URL which refers to the peer so that it refers to the provided origin
+ * instead. URLs which refer to other hosts and relative URLs are left unmodified.
+ */
+func (r *proxyRewriter) rewriteURL(origin *url.URL, location string) string {
+  
+  u, err := url.Parse(location)
+  if err != nil {
+    return location
+  }
+  
+  if u.Host != "" {
+    if !strings.EqualFold(u.Host, r.peer.Host) {
+      return location // some other host entirely
+    }
+    u.Scheme = origin.Scheme
+    u.Host = origin.Host
+  }else if !strings.HasPrefix(u.Path, "/") {
+    return location // relative paths resolve against our origin already
+  }
+  
+  // strip the peer's base path, if it has one
+  if base := strings.TrimSuffix(r.peer.Path, "/"); base != "" {
+    if u.Path == base {
+      u.Path = "/"
+    }else if strings.HasPrefix(u.Path, base +"/") {
+      u.Path = u.Path[len(base):]
+    }
+  }
+  
+  return u.String()
+}
+
+/**
+ * Rewrite a Refresh header, which is formatted as '<delay>; url=<location>'
+ */
+func (r *proxyRewriter) rewriteRefresh(origin *url.URL, refresh string) string {
+  if i := strings.Index(strings.ToLower(refresh), "url="); i < 0 {
+    return refresh
+  }else{
+    return refresh[:i+4] + r.rewriteURL(origin, strings.Trim(refresh[i+4:], " '\""))
+  }
+}
+
+/**
+ * Add permissive CORS headers to a response. If no origin is provided the request
+ * origin is echoed back, which permits credentialed requests from any origin.
+ */
+func addCORSHeaders(request *http.Request, header http.Header, origin string) {
+  
+  if origin == "" {
+    if o := request.Header.Get("Origin"); o != "" {
+      origin = o
+      header.Set("Access-Control-Allow-Credentials", "true")
+      header.Add("Vary", "Origin")
+    }else{
+      origin = "*"
+    }
+  }
+  
+  header.Set("Access-Control-Allow-Origin", origin)
+  header.Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+  
+  if h := request.Header.Get("Access-Control-Request-Headers"); h != "" {
+    header.Set("Access-Control-Allow-Headers", h)
+  }
+  
+}
+
+/**
+ * Determine whether a request is a CORS preflight request
+ */
+func isPreflightRequest(request *http.Request) bool {
+  return request.Method == "OPTIONS" && request.Header.Get("Access-Control-Request-Method") != ""
+}
+
+/**
+ * Obtain the origin a request was made to
+ */
+func requestOrigin(request *http.Request) *url.URL {
+  if request.TLS != nil {
+    return &url.URL{Scheme:"https", Host:request.Host}
+  }else{
+    return &url.URL{Scheme:"http", Host:request.Host}
+  }
+}
+
+/**
+ * Rewrite a Set-Cookie header value so the cookie is scoped to whatever host it is
+ * served from. The Domain attribute is removed and, when we are not serving over
+ * HTTPS, the Secure attribute (and SameSite=None, which requires it) is removed.
+ */
+func rewriteCookie(cookie string, secure bool) string {
+  parts := strings.Split(cookie, ";")
+  rewritten := parts[:1]
+  
+  for _, e := range parts[1:] {
+    attr := strings.TrimSpace(e)
+    name, value := attr, ""
+    if i := strings.Index(attr, "="); i >= 0 {
+      name, value = strings.TrimSpace(attr[:i]), strings.TrimSpace(attr[i+1:])
+    }
+    switch strings.ToLower(name) {
+      case "domain":
+        continue
+      case "secure":
+        if !secure { continue }
+      case "samesite":
+        if !secure && strings.EqualFold(value, "none") { continue }
+    }
+    rewritten = append(rewritten, " "+ attr)
+  }
+  
+  return strings.Join(rewritten, ";")
+}
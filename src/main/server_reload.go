@@ -0,0 +1,156 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "os"
+  "sync"
+  "time"
+  "reflect"
+)
+
+import (
+  "net/http"
+)
+
+/**
+ * A handler which delegates to another handler that can be replaced while requests
+ * are being served
+ */
+type swappableHandler struct {
+  sync.RWMutex
+  handler   http.Handler
+}
+
+/**
+ * Create a swappable handler
+ */
+func newSwappableHandler(handler http.Handler) *swappableHandler {
+  return &swappableHandler{handler: handler}
+}
+
+/**
+ * Replace the handler
+ */
+func (h *swappableHandler) set(handler http.Handler) {
+  h.Lock()
+  defer h.Unlock()
+  h.handler = handler
+}
+
+/**
+ * Handle a request
+ */
+func (h *swappableHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+  h.RLock()
+  handler := h.handler
+  h.RUnlock()
+  handler.ServeHTTP(writer, request)
+}
+
+/**
+ * Watch files for changes and send the path of a file on the provided channel when it
+ * changes. Files are polled at the specified interval. Empty paths are ignored.
+ */
+func watchFiles(paths []string, interval time.Duration, changes chan<- string) {
+  stamps := make(map[string]time.Time)
+  
+  stamp := func(path string) time.Time {
+    if info, err := os.Stat(path); err != nil {
+      return time.Time{}
+    }else{
+      return info.ModTime()
+    }
+  }
+  
+  for _, e := range paths {
+    if e != "" {
+      stamps[e] = stamp(e)
+    }
+  }
+  
+  if len(stamps) < 1 {
+    return
+  }
+  
+  for range time.Tick(interval) {
+    for k, v := range stamps {
+      if t := stamp(k); !t.Equal(v) {
+        stamps[k] = t
+        changes <- k
+      }
+    }
+  }
+  
+}
+
+/**
+ * Describe the differences between two sets of options. Changes which can be applied
+ * to a running server are returned first, followed by those which require a restart.
+ */
+func diffOptions(a, b *Options) ([]string, []string) {
+  var changed, ignored []string
+  
+  check := func(name string, x, y interface{}) {
+    if !reflect.DeepEqual(x, y) { changed = append(changed, name) }
+  }
+  
+  check("logging",              a.Flags, b.Flags)
+  check("routes",               []interface{}{a.Routes, a.RouteRules}, []interface{}{b.Routes, b.RouteRules})
+  check("proxy",                []interface{}{a.Server.Proxy, a.Proxy}, []interface{}{b.Server.Proxy, b.Proxy})
+  check("document root",        a.Server.Root, b.Server.Root)
+  check("variables",            a.Variables, b.Variables)
+  check("stylesheet minify",    a.Stylesheet.Minify, b.Stylesheet.Minify)
+  check("stylesheet exclude",   a.Stylesheet.Exclude, b.Stylesheet.Exclude)
+  check("javascript minify",    a.Javascript.Minify, b.Javascript.Minify)
+  check("javascript exclude",   a.Javascript.Exclude, b.Javascript.Exclude)
+  check("circular imports",     a.Javascript.CircularImports, b.Javascript.CircularImports)
+  check("legacy directives",    a.Javascript.LegacyDirectives, b.Javascript.LegacyDirectives)
+  check("remote imports",       a.Imports, b.Imports)
+  check("fallback",             a.Server.Fallback, b.Server.Fallback)
+  check("directory listing",    a.Server.Listing, b.Server.Listing)
+  check("compression",          a.Server.Compress, b.Server.Compress)
+  check("mocks",                a.Mocks, b.Mocks)
+  check("headers",              a.Headers, b.Headers)
+  check("network",              a.Network, b.Network)
+  
+  restart := func(name string, x, y interface{}) {
+    if !reflect.DeepEqual(x, y) { ignored = append(ignored, name) }
+  }
+  
+  restart("port",               a.Server.Port, b.Server.Port)
+  restart("listen",             a.Server.Listen, b.Server.Listen)
+  restart("https",              []interface{}{a.Server.HTTPS, a.Server.Redirect, a.Server.Hostnames, a.Server.Certificate, a.Server.Key, a.Server.Certificates}, []interface{}{b.Server.HTTPS, b.Server.Redirect, b.Server.Hostnames, b.Server.Certificate, b.Server.Key, b.Server.Certificates})
+  restart("har",                []interface{}{a.Server.HAR, a.Server.HARLimit}, []interface{}{b.Server.HAR, b.Server.HARLimit})
+  restart("shutdown timeout",   a.Server.ShutdownTimeout, b.Server.ShutdownTimeout)
+  
+  return changed, ignored
+}
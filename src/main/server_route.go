@@ -0,0 +1,220 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "fmt"
+  "path"
+  "sort"
+  "regexp"
+  "strings"
+)
+
+/**
+ * Route match types
+ */
+const (
+  ROUTE_MATCH_PREFIX  = "prefix"
+  ROUTE_MATCH_GLOB    = "glob"
+  ROUTE_MATCH_REGEX   = "regex"
+)
+
+/**
+ * A route rule
+ */
+type routeRule struct {
+  options   RouteOptions
+  expr      *regexp.Regexp
+}
+
+/**
+ * The result of matching a path against the route rules
+ */
+type routeMatch struct {
+  Rules       []*routeRule
+  Alternates  []string
+  Strict      bool
+  NoProxy     bool
+}
+
+/**
+ * Create route rules. Rules declared explicitly are consulted first, in the
+ * order they are declared, followed by simple prefix routes ordered from the
+ * longest (most specific) prefix to the shortest.
+ */
+func newRouteRules(rules []RouteOptions, routes map[string][]string) ([]*routeRule, error) {
+  result := make([]*routeRule, 0, len(rules) + len(routes))
+  
+  for _, e := range rules {
+    if r, err := newRouteRule(e); err != nil {
+      return nil, err
+    }else{
+      result = append(result, r)
+    }
+  }
+  
+  prefixes := make([]string, 0, len(routes))
+  for k, _ := range routes {
+    prefixes = append(prefixes, k)
+  }
+  
+  sort.Slice(prefixes, func(i, j int) bool {
+    if len(prefixes[i]) != len(prefixes[j]) {
+      return len(prefixes[i]) > len(prefixes[j])
+    }else{
+      return prefixes[i] < prefixes[j]
+    }
+  })
+  
+  for _, e := range prefixes {
+    result = append(result, &routeRule{options:RouteOptions{Match:ROUTE_MATCH_PREFIX, Pattern:e, Targets:routes[e]}})
+  }
+  
+  return result, nil
+}
+
+/**
+ * Create a route rule
+ */
+func newRouteRule(options RouteOptions) (*routeRule, error) {
+  var expr *regexp.Regexp
+  var err error
+  
+  switch options.Match {
+    case ROUTE_MATCH_PREFIX, "":
+      options.Match = ROUTE_MATCH_PREFIX
+    case ROUTE_MATCH_GLOB:
+      if expr, err = regexp.Compile(globToRegexp(options.Pattern)); err != nil {
+        return nil, fmt.Errorf("Route glob is not valid: %s: %v", options.Pattern, err)
+      }
+    case ROUTE_MATCH_REGEX:
+      if expr, err = regexp.Compile("^(?:"+ options.Pattern +")$"); err != nil {
+        return nil, fmt.Errorf("Route expression is not valid: %s: %v", options.Pattern, err)
+      }
+    default:
+      return nil, fmt.Errorf("Route match type is not supported: %s", options.Match)
+  }
+  
+  return &routeRule{options, expr}, nil
+}
+
+/**
+ * Convert a glob to an anchored regular expression. A '*' matches within a
+ * single path segment, '**' matches across segments, and '?' matches a single
+ * character. Each wildcard is captured and may be referenced as $1, $2, etc.
+ */
+func globToRegexp(glob string) string {
+  expr := "^"
+  
+  for i := 0; i < len(glob); i++ {
+    switch c := glob[i]; c {
+      case '*':
+        if i + 1 < len(glob) && glob[i + 1] == '*' {
+          expr += "(.*)"
+          i++
+        }else{
+          expr += "([^/]*)"
+        }
+      case '?':
+        expr += "([^/])"
+      default:
+        expr += regexp.QuoteMeta(string(c))
+    }
+  }
+  
+  return expr +"$"
+}
+
+/**
+ * Resolve the alternate paths for the provided absolute path. If the rule does
+ * not match, nil and false are returned.
+ */
+func (r *routeRule) resolve(absolute string) ([]string, bool) {
+  var alternates []string
+  
+  if r.expr == nil {
+    if !strings.HasPrefix(absolute, r.options.Pattern) {
+      return nil, false
+    }
+    for _, e := range r.options.Targets {
+      alternates = append(alternates, path.Join(e, absolute[len(r.options.Pattern):]))
+    }
+  }else{
+    m := r.expr.FindStringSubmatchIndex(absolute)
+    if m == nil {
+      return nil, false
+    }
+    for _, e := range r.options.Targets {
+      alternates = append(alternates, path.Join("/", string(r.expr.ExpandString(nil, e, absolute, m))))
+    }
+  }
+  
+  return alternates, true
+}
+
+/**
+ * Describe a route rule
+ */
+func (r *routeRule) String() string {
+  var flags string
+  if r.options.Strict {
+    flags += ", strict"
+  }
+  if r.options.NoProxy {
+    flags += ", never proxied"
+  }
+  return fmt.Sprintf("%s %s \u2192 {%s}%s", r.options.Match, r.options.Pattern, strings.Join(r.options.Targets, ", "), flags)
+}
+
+/**
+ * Match a path against the route rules. Every matching rule contributes its
+ * alternates, in order, until a strict rule matches; a strict rule stops
+ * matching and excludes the document root as a base for compiled resources.
+ */
+func matchRoutes(rules []*routeRule, absolute string) *routeMatch {
+  match := &routeMatch{}
+  
+  for _, e := range rules {
+    if alternates, ok := e.resolve(absolute); ok {
+      match.Rules = append(match.Rules, e)
+      match.Alternates = append(match.Alternates, alternates...)
+      if e.options.NoProxy {
+        match.NoProxy = true
+      }
+      if e.options.Strict {
+        match.Strict = true
+        break
+      }
+    }
+  }
+  
+  return match
+}
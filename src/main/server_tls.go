@@ -0,0 +1,271 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "os"
+  "fmt"
+  "log"
+  "net"
+  "time"
+  "bytes"
+  "io/ioutil"
+  "math/big"
+  "path/filepath"
+)
+
+import (
+  "net/http"
+  "crypto/tls"
+  "crypto/rand"
+  "crypto/x509"
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "crypto/x509/pkix"
+  "encoding/pem"
+)
+
+const (
+  TLS_CA_CERTIFICATE    = "ca.pem"
+  TLS_CA_KEY            = "ca-key.pem"
+  TLS_LEAF_CERTIFICATE  = "localhost.pem"
+  TLS_LEAF_KEY          = "localhost-key.pem"
+)
+
+/**
+ * Hostnames that are always included in local certificates
+ */
+var localHostnames = []string{
+  "localhost",
+  "127.0.0.1",
+  "::1",
+}
+
+/**
+ * Obtain the certificate the server should use. If a certificate and key are provided
+ * they are used, otherwise a local certificate is issued (and persisted) by a local
+ * certificate authority.
+ */
+func loadCertificate(options ServerOptions) (tls.Certificate, error) {
+  if options.Certificate != "" || options.Key != "" {
+    return tls.LoadX509KeyPair(options.Certificate, options.Key)
+  }
+  dir := options.Certificates
+  if dir == "" {
+    if home, err := os.UserHomeDir(); err != nil {
+      return tls.Certificate{}, fmt.Errorf("Could not determine certificate directory: %v", err)
+    }else{
+      dir = filepath.Join(home, ".slang", "certs")
+    }
+  }
+  return localCertificate(dir, options.Hostnames)
+}
+
+/**
+ * Create a handler which redirects every request to the same resource over HTTPS on
+ * the specified port
+ */
+func httpsRedirectHandler(port int) http.Handler {
+  return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+    host := request.Host
+    if h, _, err := net.SplitHostPort(host); err == nil {
+      host = h
+    }
+    if port != 443 {
+      host = net.JoinHostPort(host, fmt.Sprintf("%d", port))
+    }
+    http.Redirect(writer, request, "https://"+ host + request.URL.RequestURI(), http.StatusMovedPermanently)
+  })
+}
+
+/**
+ * Obtain a local certificate for the specified hostnames. The certificate is reused if
+ * it is still valid and covers every hostname, otherwise a new one is issued.
+ */
+func localCertificate(dir string, hostnames []string) (tls.Certificate, error) {
+  
+  if err := os.MkdirAll(dir, 0700); err != nil {
+    return tls.Certificate{}, err
+  }
+  
+  ca, caKey, err := localCertificateAuthority(dir)
+  if err != nil {
+    return tls.Certificate{}, err
+  }
+  
+  hosts := append(append([]string{}, localHostnames...), hostnames...)
+  certPath, keyPath := filepath.Join(dir, TLS_LEAF_CERTIFICATE), filepath.Join(dir, TLS_LEAF_KEY)
+  
+  if cert, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil {
+    if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil && isUsableCertificate(leaf, ca, hosts) {
+      return cert, nil
+    }
+  }
+  
+  key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  if err != nil {
+    return tls.Certificate{}, err
+  }
+  
+  template := &x509.Certificate{
+    SerialNumber: newSerialNumber(),
+    Subject: pkix.Name{Organization: []string{"Slang"}, CommonName: "localhost"},
+    NotBefore: time.Now().Add(-time.Hour),
+    NotAfter: time.Now().Add(365 * 24 * time.Hour),
+    KeyUsage: x509.KeyUsageDigitalSignature,
+    ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+  }
+  
+  for _, e := range hosts {
+    if ip := net.ParseIP(e); ip != nil {
+      template.IPAddresses = append(template.IPAddresses, ip)
+    }else{
+      template.DNSNames = append(template.DNSNames, e)
+    }
+  }
+  
+  der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+  if err != nil {
+    return tls.Certificate{}, err
+  }
+  
+  if err := writeCertificate(certPath, keyPath, der, key); err != nil {
+    return tls.Certificate{}, err
+  }
+  
+  return tls.Certificate{Certificate: [][]byte{der, ca.Raw}, PrivateKey: key}, nil
+}
+
+/**
+ * Obtain the local certificate authority, creating it if necessary
+ */
+func localCertificateAuthority(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+  certPath, keyPath := filepath.Join(dir, TLS_CA_CERTIFICATE), filepath.Join(dir, TLS_CA_KEY)
+  
+  if _, err := os.Stat(certPath); err == nil {
+    if cert, err := tls.LoadX509KeyPair(certPath, keyPath); err != nil {
+      return nil, nil, fmt.Errorf("Could not load certificate authority: %v", err)
+    }else if ca, err := x509.ParseCertificate(cert.Certificate[0]); err != nil {
+      return nil, nil, err
+    }else if key, ok := cert.PrivateKey.(*ecdsa.PrivateKey); !ok {
+      return nil, nil, fmt.Errorf("Certificate authority key is not supported: %s", keyPath)
+    }else{
+      return ca, key, nil
+    }
+  }else if !os.IsNotExist(err) {
+    return nil, nil, err
+  }
+  
+  key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  if err != nil {
+    return nil, nil, err
+  }
+  
+  template := &x509.Certificate{
+    SerialNumber: newSerialNumber(),
+    Subject: pkix.Name{Organization: []string{"Slang"}, CommonName: "Slang Local Certificate Authority"},
+    NotBefore: time.Now().Add(-time.Hour),
+    NotAfter: time.Now().Add(10 * 365 * 24 * time.Hour),
+    KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+    BasicConstraintsValid: true,
+    IsCA: true,
+    MaxPathLenZero: true,
+  }
+  
+  der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+  if err != nil {
+    return nil, nil, err
+  }
+  
+  if err := writeCertificate(certPath, keyPath, der, key); err != nil {
+    return nil, nil, err
+  }
+  
+  ca, err := x509.ParseCertificate(der)
+  if err != nil {
+    return nil, nil, err
+  }
+  
+  log.Printf("Created a local certificate authority: %s", certPath)
+  log.Printf("Add it to your trusted certificates to avoid browser warnings.")
+  
+  return ca, key, nil
+}
+
+/**
+ * Determine whether a certificate was issued by the provided authority, is valid for
+ * a while longer, and covers all the specified hostnames
+ */
+func isUsableCertificate(cert, ca *x509.Certificate, hosts []string) bool {
+  if !bytes.Equal(cert.RawIssuer, ca.RawSubject) || cert.CheckSignatureFrom(ca) != nil {
+    return false
+  }
+  if time.Now().Add(7 * 24 * time.Hour).After(cert.NotAfter) {
+    return false
+  }
+  for _, e := range hosts {
+    if cert.VerifyHostname(e) != nil {
+      return false
+    }
+  }
+  return true
+}
+
+/**
+ * Write a certificate and its key as PEM
+ */
+func writeCertificate(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+  
+  keyDER, err := x509.MarshalECPrivateKey(key)
+  if err != nil {
+    return err
+  }
+  
+  if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+    return err
+  }
+  if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+    return err
+  }
+  
+  return nil
+}
+
+/**
+ * Produce a random certificate serial number
+ */
+func newSerialNumber() *big.Int {
+  serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+  if err != nil {
+    panic(err)
+  }
+  return serial
+}
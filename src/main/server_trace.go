@@ -0,0 +1,213 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "time"
+  "context"
+)
+
+import (
+  "net/http"
+)
+
+const (
+  TraceSourceNone     = ""
+  TraceSourceLocal    = "local"
+  TraceSourceProxy    = "proxy"
+  TraceSourceMock     = "mock"
+  TraceSourceError    = "error"
+)
+
+/**
+ * Context key for request traces
+ */
+type traceContextKey struct{}
+
+/**
+ * A request trace. This records how the server handled a request: which resources it
+ * considered, which one it served, how long compilation took, and so on.
+ */
+type requestTrace struct {
+  Started     time.Time
+  Responded   time.Time
+  Finished    time.Time
+  Request     *http.Request
+  Header      http.Header
+  Status      int
+  Size        int64
+  Source      string
+  Candidates  []string
+  Resource    string
+  Compile     time.Duration
+  Error       error
+}
+
+/**
+ * Begin tracing a request. A derived request which carries the trace is returned.
+ */
+func beginTrace(request *http.Request) (*http.Request, *requestTrace) {
+  trace := &requestTrace{Started: time.Now(), Request: request}
+  return request.WithContext(context.WithValue(request.Context(), traceContextKey{}, trace)), trace
+}
+
+/**
+ * Obtain the trace for a request, if there is one. The methods on a trace are safe to
+ * call on nil, so callers needn't check.
+ */
+func traceForRequest(request *http.Request) *requestTrace {
+  if t, ok := request.Context().Value(traceContextKey{}).(*requestTrace); ok {
+    return t
+  }else{
+    return nil
+  }
+}
+
+/**
+ * Note the resources considered for a request
+ */
+func (t *requestTrace) addCandidates(candidates []string) {
+  if t != nil { t.Candidates = append(t.Candidates, candidates...) }
+}
+
+/**
+ * Note the local resource that was served for a request
+ */
+func (t *requestTrace) setResource(resource string) {
+  if t != nil { t.Source = TraceSourceLocal; t.Resource = resource }
+}
+
+/**
+ * Obtain the local resource that was served for a request, if any
+ */
+func (t *requestTrace) resource() string {
+  if t != nil {
+    return t.Resource
+  }
+  return ""
+}
+
+/**
+ * Note the source that served a request
+ */
+func (t *requestTrace) setSource(source string) {
+  if t != nil { t.Source = source }
+}
+
+/**
+ * Note the time spent compiling a resource
+ */
+func (t *requestTrace) setCompile(d time.Duration) {
+  if t != nil { t.Compile = d }
+}
+
+/**
+ * Note the error that was produced for a request
+ */
+func (t *requestTrace) setError(err error) {
+  if t != nil { t.Source = TraceSourceError; t.Error = err }
+}
+
+/**
+ * Obtain the total duration of a request
+ */
+func (t *requestTrace) Duration() time.Duration {
+  return t.Finished.Sub(t.Started)
+}
+
+/**
+ * A response writer which records the response in a trace
+ */
+type traceWriter struct {
+  http.ResponseWriter
+  trace     *requestTrace
+}
+
+/**
+ * Write the response header
+ */
+func (w *traceWriter) WriteHeader(status int) {
+  if w.trace.Status == 0 {
+    w.trace.Status = status
+    w.trace.Responded = time.Now()
+    w.trace.Header = w.Header().Clone()
+  }
+  w.ResponseWriter.WriteHeader(status)
+}
+
+/**
+ * Write response data
+ */
+func (w *traceWriter) Write(b []byte) (int, error) {
+  if w.trace.Status == 0 {
+    w.WriteHeader(http.StatusOK)
+  }
+  n, err := w.ResponseWriter.Write(b)
+  w.trace.Size += int64(n)
+  return n, err
+}
+
+/**
+ * Flush the response, if the underlying writer supports it
+ */
+func (w *traceWriter) Flush() {
+  if f, ok := w.ResponseWriter.(http.Flusher); ok {
+    f.Flush()
+  }
+}
+
+/**
+ * A request observer, which is notified of each request the server handles after
+ * it has been handled
+ */
+type requestObserver interface {
+  observeRequest(trace *requestTrace)
+}
+
+/**
+ * Wrap a handler so that every request it handles is traced and the trace is
+ * provided to the specified observers
+ */
+func traceHandler(handler http.Handler, observers ...requestObserver) http.Handler {
+  return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+    request, trace := beginTrace(request)
+    handler.ServeHTTP(&traceWriter{writer, trace}, request)
+    trace.Finished = time.Now()
+    if trace.Status == 0 {
+      trace.Status = http.StatusOK
+      trace.Responded = trace.Finished
+      trace.Header = writer.Header().Clone()
+    }
+    for _, e := range observers {
+      e.observeRequest(trace)
+    }
+  })
+}
@@ -34,20 +34,52 @@ import (
   "os"
   "io"
   "fmt"
+  "log"
   "flag"
+  "time"
   "strings"
+  "syscall"
+  "os/signal"
   "path/filepath"
 )
 
 import (
+  "mime"
+  "net/http"
   "io/ioutil"
   "encoding/json"
 )
 
+const (
+  VERSION         = "3"
+)
+
+const (
+  EXIT_SUCCESS    = 0
+  EXIT_COMPILE    = 1
+  EXIT_CONFIG     = 2
+  EXIT_IO         = 3
+)
+
+const (
+  RESOURCE_COMPILED = "compiled"
+  RESOURCE_COPIED   = "copied"
+  RESOURCE_SKIPPED  = "skipped"
+  RESOURCE_FAILED   = "failed"
+)
+
+/**
+ * Returned to stop walking resources when a build cannot continue
+ */
+var errBuildStopped = fmt.Errorf("Build stopped")
+
 const (
   COMMAND_INIT    = "init"
   COMMAND_RUN     = "run"
   COMMAND_BUILD   = "build"
+  COMMAND_ROUTE   = "route"
+  COMMAND_DEPS    = "deps"
+  COMMAND_VENDOR  = "vendor"
   COMMAND_HELP    = "help"
 )
 
@@ -69,16 +101,42 @@ func main() {
   cmdline.Var(&fDefines, "D", "Define a variable as '<ident>=<value>'. This is the equivalent of a top-level variable defined via -vars.")
   
   fPort       := cmdline.Int    ("port",        9090,           "The port on which to run the built-in server.")
+  fListen     := ListParams{}
+  cmdline.Var(&fListen, "listen", "An address on which to run the built-in server, either '<host>:<port>' or 'unix:<path>'. May be specified more than once. (Overrides -port)")
   fProxy      := cmdline.String ("proxy",       "",             "The base URL the built-in server should reverse-proxy for unmanaged resources.")
+  fProxyCache := cmdline.String ("proxy:cache", "",             "Record proxied responses or replay them offline: 'record', 'replay', or 'fallback'.")
+  fHAR        := cmdline.String ("har",         "",             "Record all traffic through the built-in server and write it to the specified HTTP Archive (HAR) file on exit.")
+  fHARLimit   := cmdline.Int    ("har:limit",   0,              "Record at most this many of the most recent requests, instead of the configured limit.")
+  fHTTPS      := cmdline.Bool   ("https",       false,          "Serve HTTPS from the built-in server using a locally issued certificate.")
+  fRedirect   := cmdline.Int    ("https:redirect", 0,           "Also listen for HTTP on the specified port and redirect requests to HTTPS.")
+  fFallback   := cmdline.String ("fallback",    "",             "Serve the specified index document for navigations that don't match a resource, e.g., for a single-page app using client-side routing.")
+  fListing    := cmdline.Bool   ("listing",     false,          "Serve a listing for directories that don't have an index document.")
   fRoutes     := make(AssocParams)
   cmdline.Var(&fRoutes, "route", "Routing rules, formatted as '<remote>=<local>'; e.g., slang -server -route /css=/styles -route /js=/app/js [...].")
   
+  fLatency    := cmdline.Duration ("net:latency",   0,  "Simulate network latency by delaying every response served by the built-in server.")
+  fBandwidth  := cmdline.Int      ("net:bandwidth", 0,  "Simulate limited bandwidth by throttling responses to the specified number of bytes per second.")
+  fFailure    := cmdline.Float64  ("net:failure",   0,  "Simulate an unreliable network by failing the specified fraction (0-1) of requests.")
+  
   fOutput     := cmdline.String ("output",      "./slang.out",  "Specify the path to write compiled resources to.")
   fCopy       := cmdline.Bool   ("copy",        false,          "Copy unmanaged resources to output when compiling.")
+  fCompress   := cmdline.String ("compress",    "",             "Also write precompressed copies of text resources when compiling, as a comma-separated list of 'gzip' and 'br'.")
+  fThreshold  := cmdline.Int64  ("compress:threshold", 0,       "Only precompress resources at least this many bytes in size, instead of the configured threshold.")
+  fFormat     := cmdline.String ("format",      DIAGNOSTIC_FORMAT_TEXT, "The format in which to report problems when compiling: 'text' ('file:line:column: severity: message') or 'json'. Dependencies can also be printed as 'dot'.")
+  fKeepGoing  := cmdline.Bool   ("keep-going",  false,          "Keep compiling after an I/O error instead of stopping the build.")
+  fStage      := cmdline.Bool   ("stage",       false,          "Build into a staging directory and swap it with the output when the build succeeds, keeping the previous build.")
+  fRollback   := cmdline.Bool   ("rollback",    false,          "Swap the output with the build it replaced instead of compiling.")
+  fReport     := cmdline.Bool   ("report",      false,          "Print the size of every asset when compiling, along with what each import contributes to a bundle.")
+  fHeaders    := cmdline.String ("headers",     "",             "Export the configured response headers when compiling, as 'netlify' (_headers) or 'nginx'.")
   
   fMinify     := cmdline.Bool   ("minify",      false,          "Minify resources that can be minified.")
   fMinifyCSS  := cmdline.Bool   ("css:minify",  false,          "Minify stylesheets resources.")
   fMinifyJS   := cmdline.Bool   ("js:minify",   false,          "Minify Javascript resources.")
+  fOffline    := cmdline.Bool   ("imports:offline", false,      "Only use cached copies of remote imports; never fetch them.")
+  fVendorPath := cmdline.String ("vendor:path", "",             "The directory to vendor remote imports into. (default ./vendor)")
+  fRewrite    := cmdline.Bool   ("vendor:rewrite", false,       "Rewrite remote imports to refer to their vendored copies.")
+  fForce      := cmdline.Bool   ("force",       false,          "Overwrite vendored files even if they have been modified.")
+  fLegacyJS   := cmdline.Bool   ("js:legacy-directives", false, "Expand EJS directives at the start of any line, even in comments and strings, as older versions did.")
   fShip       := cmdline.Bool   ("ship",        false,          "Turn on all presets for shipping a project.")
   
   fQuiet      := cmdline.Bool   ("quiet",       false,          "Be quiet. Only print error messages. (Overrides -verbose, -debug)")
@@ -87,70 +145,186 @@ func main() {
   
   cmdline.Parse(os.Args[2:])
   
-  // initialize our options
-  options := InitOptions(*fConfig, cmdline.Args())
-  
   // do init if requested and exit
   if command == COMMAND_INIT {
-    runInit(options); return
+    runInit(InitOptions(*fConfig, cmdline.Args())); return
   }
   
-  // variables
-  variables := make(map[string]interface{})
-  
-  // load variables
-  if *fVariables != "" {
-    if serial, err := ioutil.ReadFile(*fVariables); err != nil {
-      fmt.Printf("Could not read variables: %v\n", err)
-      return
-    }else if err := json.Unmarshal(serial, &variables); err != nil{
-      fmt.Printf("Variables are not valid: %v\n", err)
-      return
+  // configure our options from our config file, variables and the command line; this
+  // is also used to reload our configuration while the server is running
+  configure := func() (*Options, error) {
+    
+    options, err := LoadOptions(*fConfig, cmdline.Args())
+    if err != nil {
+      return nil, err
     }
-  }
-  
-  // use as shared gloabls
-  options.Variables = variables
-  
-  // add variables defined on the command line
-  if len(fDefines) > 0 {
-    for k, v := range fDefines {
-      if len(v) > 0 {
-        options.Variables[k] = v[len(v) - 1] // use the last one
+    
+    // variables
+    variables := make(map[string]interface{})
+    
+    // load variables
+    if *fVariables != "" {
+      if serial, err := ioutil.ReadFile(*fVariables); err != nil {
+        return nil, fmt.Errorf("Could not read variables: %v", err)
+      }else if err := json.Unmarshal(serial, &variables); err != nil{
+        return nil, fmt.Errorf("Variables are not valid: %v", err)
       }
     }
+    
+    // use as shared gloabls
+    options.Variables = variables
+    
+    // add variables defined on the command line
+    if len(fDefines) > 0 {
+      for k, v := range fDefines {
+        if len(v) > 0 {
+          options.Variables[k] = v[len(v) - 1] // use the last one
+        }
+      }
+    }
+    
+    // server config
+    if *fPort != options.Server.Port {
+      options.Server.Port = *fPort
+    }
+    if len(fListen) > 0 {
+      options.Server.Listen = fListen
+    }
+    if *fProxy != "" {
+      options.Server.Proxy = *fProxy
+    }
+    if *fHTTPS {
+      options.Server.HTTPS = true
+    }
+    if *fRedirect > 0 {
+      options.Server.Redirect = *fRedirect
+    }
+    if *fHAR != "" {
+      options.Server.HAR = *fHAR
+    }
+    if *fHARLimit > 0 {
+      options.Server.HARLimit = *fHARLimit
+    }
+    if *fProxyCache != "" {
+      options.Proxy.Cache = *fProxyCache
+    }
+    if *fFallback != "" {
+      options.Server.Fallback = *fFallback
+    }
+    if *fListing {
+      options.Server.Listing = true
+    }
+    
+    // network simulation
+    if *fLatency != 0 {
+      options.Network.Latency = *fLatency
+    }
+    if *fBandwidth != 0 {
+      options.Network.Bandwidth = *fBandwidth
+    }
+    if *fFailure != 0 {
+      options.Network.FailureRate = *fFailure
+    }
+    if err := options.Network.Validate(); err != nil {
+      return nil, err
+    }
+    
+    // routes definitions
+    if len(fRoutes) > 0 {
+      options.Routes = fRoutes
+    }
+    
+    // compilation options
+    if *fShip || *fMinify || *fMinifyCSS { options.Stylesheet.Minify = true }
+    if *fShip || *fMinify || *fMinifyJS  { options.Javascript.Minify = true }
+    if *fLegacyJS { options.Javascript.LegacyDirectives = true }
+    if *fOffline  { options.Imports.Offline = true }
+    
+    // vendoring options
+    if *fVendorPath != "" { options.Vendor.Path = *fVendorPath }
+    if *fRewrite  { options.Vendor.Rewrite = true }
+    if *fForce    { options.Vendor.Force = true }
+    
+    // build options
+    if *fCompress != "" {
+      options.Build.Compress = nil
+      for _, e := range strings.Split(*fCompress, ",") {
+        if e = strings.TrimSpace(e); e == "" {
+          continue
+        }else if _, ok := ENCODING_EXTENSIONS[e]; !ok {
+          return nil, fmt.Errorf("Compression format is not supported: %s", e)
+        }
+        options.Build.Compress = append(options.Build.Compress, e)
+      }
+    }
+    if *fThreshold > 0 {
+      options.Build.CompressThreshold = *fThreshold
+    }
+    switch *fFormat {
+      case DIAGNOSTIC_FORMAT_TEXT, DIAGNOSTIC_FORMAT_JSON:
+        options.Build.Format = *fFormat
+      case DEPS_FORMAT_DOT:
+        if command != COMMAND_DEPS {
+          return nil, fmt.Errorf("Format is only supported for dependencies: %s", *fFormat)
+        }
+        options.Build.Format = *fFormat
+      default:
+        return nil, fmt.Errorf("Format is not supported: %s", *fFormat)
+    }
+    if *fKeepGoing {
+      options.Build.KeepGoing = true
+    }
+    if *fStage {
+      options.Build.Stage = true
+    }
+    if *fReport {
+      options.Build.Report = true
+    }
+    
+    // unmanaged resource options
+    if *fCopy { options.Unmanaged.Copy = true }
+    
+    // apply command line flags
+    if *fQuiet    { options.SetFlag(OptionsFlagQuiet,   *fQuiet) }
+    if *fVerbose  { options.SetFlag(OptionsFlagVerbose, *fVerbose && !options.GetFlag(OptionsFlagQuiet)) }
+    if *fDebug    { options.SetFlag(OptionsFlagDebug,   *fDebug   && !options.GetFlag(OptionsFlagQuiet)) }
+    
+    // structured output is the only output
+    if command == COMMAND_BUILD && options.Build.Format == DIAGNOSTIC_FORMAT_JSON {
+      options.SetFlag(OptionsFlagQuiet, true)
+      options.SetFlag(OptionsFlagVerbose, false)
+      options.SetFlag(OptionsFlagDebug, false)
+    }
+    
+    return options, nil
   }
   
-  // server config
-  if *fPort != options.Server.Port {
-    options.Server.Port = *fPort
-  }
-  if *fProxy != "" {
-    options.Server.Proxy = *fProxy
-  }
-  
-  // routes definitions
-  if len(fRoutes) > 0 {
-    options.Routes = fRoutes
+  // initialize our options
+  options, err := configure()
+  if err != nil {
+    fmt.Println(err)
+    os.Exit(EXIT_CONFIG)
   }
   
-  // compilation options
-  if *fShip || *fMinify || *fMinifyCSS { options.Stylesheet.Minify = true }
-  if *fShip || *fMinify || *fMinifyJS  { options.Javascript.Minify = true }
-  
-  // unmanaged resource options
-  if *fCopy { options.Unmanaged.Copy = true }
-  
-  // apply command line flags
-  if *fQuiet    { options.SetFlag(OptionsFlagQuiet,   *fQuiet) }
-  if *fVerbose  { options.SetFlag(OptionsFlagVerbose, *fVerbose && !options.GetFlag(OptionsFlagQuiet)) }
-  if *fDebug    { options.SetFlag(OptionsFlagDebug,   *fDebug   && !options.GetFlag(OptionsFlagQuiet)) }
+  // setup shared options
+  SetSharedOptions(options)
   
   // do something useful
   if command == COMMAND_RUN {
-    runServer(options, cmdline.Args())
+    runServer(options, cmdline.Args(), configure, []string{options.ConfigPath(), *fVariables})
   }else if command == COMMAND_BUILD {
-    runCompile(options, *fOutput, cmdline.Args())
+    if *fRollback {
+      os.Exit(runRollback(options, *fOutput))
+    }
+    if status := runBuild(options, *fOutput, *fHeaders, cmdline.Args()); status != EXIT_SUCCESS {
+      os.Exit(status)
+    }
+  }else if command == COMMAND_ROUTE {
+    runRoute(options, cmdline.Args())
+  }else if command == COMMAND_DEPS {
+    os.Exit(runDeps(options, cmdline.Args()))
+  }else if command == COMMAND_VENDOR {
+    os.Exit(runVendor(options, cmdline.Args()))
   }else if command == COMMAND_HELP {
     runHelp(cmdline, true)
   }else{
@@ -165,10 +339,10 @@ func main() {
 func runHelp(cmdline *flag.FlagSet, detail bool) {
   
   if !detail {
-    fmt.Println("Usage: slang (run|build|init) [options]");
+    fmt.Println("Usage: slang (run|build|route|deps|vendor|init) [options]");
     fmt.Println(" Help: slang help");
   }else{
-    fmt.Println("Usage: slang (run|build|init) [options]");
+    fmt.Println("Usage: slang (run|build|route|deps|vendor|init) [options]");
     fmt.Println()
     fmt.Println("Initialize an optional slang.conf file:")
     fmt.Println("  $ slang init")
@@ -179,6 +353,15 @@ func runHelp(cmdline *flag.FlagSet, detail bool) {
     fmt.Println("Traverse a directory and compile all supported assets found in it:")
     fmt.Println("  $ slang build -output ./build ./assets")
     fmt.Println()
+    fmt.Println("Explain how the built-in server would route a request:")
+    fmt.Println("  $ slang route /css/style.css [./docroot]")
+    fmt.Println()
+    fmt.Println("Print the dependency graph of the assets in a directory (-format text, dot or json):")
+    fmt.Println("  $ slang deps [./assets]")
+    fmt.Println()
+    fmt.Println("Download the remote imports in a directory so they can be committed:")
+    fmt.Println("  $ slang vendor -vendor:path ./assets/vendor -vendor:rewrite [./assets]")
+    fmt.Println()
   }
   
   if cmdline != nil {
@@ -221,45 +404,204 @@ func runInit(options *Options) {
 /**
  * Service
  */
-func runServer(options *Options, args []string) {
+func runServer(options *Options, args []string, configure func() (*Options, error), watch []string) {
   var server *Server
-  var root string
   var err error
   
-  if len(args) > 0 {
-    root = args[0]
-  }else if options.Server.Root != "" {
-    root = options.Server.Root
-  }else{
-    root = "."
-  }
-  
-  if server, err = NewServer(options.Server.Port, options.Server.Proxy, root, options.Routes); err != nil {
+  if server, err = NewServer(options, serverRoot(options, args)); err != nil {
     fmt.Println(err)
     return
   }
   
+  scheme := "http"
+  if options.Server.HTTPS {
+    scheme = "https"
+  }
+  
+  addresses := []string{fmt.Sprintf("%s://localhost:%d/", scheme, options.Server.Port)}
+  if len(options.Server.Listen) > 0 {
+    addresses = make([]string, len(options.Server.Listen))
+    for i, e := range options.Server.Listen {
+      if strings.HasPrefix(e, listenUnixPrefix) {
+        addresses[i] = e
+      }else{
+        addresses[i] = fmt.Sprintf("%s://%s/", scheme, e)
+      }
+    }
+  }
+  
   if options.Server.Proxy != "" {
-    fmt.Printf("Starting the Slang server: http://localhost:%d/ <-> %s\n", options.Server.Port, options.Server.Proxy)
+    fmt.Printf("Starting the Slang server: %s <-> %s\n", strings.Join(addresses, ", "), options.Server.Proxy)
   }else{
-    fmt.Printf("Starting the Slang server: http://localhost:%d/\n", options.Server.Port)
+    fmt.Printf("Starting the Slang server: %s\n", strings.Join(addresses, ", "))
+  }
+  
+  // reload our configuration and replace the server's routes, proxy, etc. The
+  // replacement is built from the new options before anything is published, so a
+  // configuration which can't be loaded never takes effect. Each server serves with
+  // its own options, so requests that are in flight finish with the configuration
+  // they started with.
+  reload := func(reason string) {
+    log.Printf("Reloading configuration (%s)", reason)
+    
+    next, err := configure()
+    if err != nil {
+      log.Printf("ERROR: Could not reload configuration: %v", err)
+      return
+    }
+    
+    replacement, err := NewServer(next, serverRoot(next, args))
+    if err != nil {
+      log.Printf("ERROR: Could not reload configuration: %v", err)
+      return
+    }
+    
+    previous := SharedOptions()
+    SetSharedOptions(next)
+    server.Reload(replacement)
+    
+    changed, ignored := diffOptions(previous, next)
+    if len(changed) > 0 {
+      log.Printf("Configuration reloaded; changed: %s", strings.Join(changed, ", "))
+    }else{
+      log.Printf("Configuration reloaded; nothing changed")
+    }
+    if len(ignored) > 0 {
+      log.Printf("Restart the server to apply changes to: %s", strings.Join(ignored, ", "))
+    }
   }
   
-  if err := server.Run(); err != nil {
+  // reload when our configuration changes
+  changes := make(chan string, 1)
+  go watchFiles(watch, time.Second, changes)
+  
+  // reload on SIGHUP, drain and shut down on SIGINT or SIGTERM (twice to force it)
+  signals := make(chan os.Signal, 1)
+  signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+  
+  done := make(chan struct{})
+  go func() {
+    var stopping bool
+    for {
+      select {
+        case sig := <- signals:
+          if sig == syscall.SIGHUP {
+            reload("SIGHUP")
+          }else if stopping {
+            fmt.Println("Stopping now")
+            os.Exit(-1)
+          }else{
+            stopping = true
+            fmt.Println("Stopping the Slang server...")
+            go func() {
+              if err := server.Shutdown(options.Server.ShutdownTimeout); err != nil { // as started; changing this requires a restart
+                fmt.Println(err)
+              }
+              close(done)
+            }()
+          }
+        case path := <- changes:
+          reload(path +" changed")
+      }
+    }
+  }()
+  
+  if err := server.Run(); err != nil && err != http.ErrServerClosed {
     fmt.Println(err)
     return
   }
   
+  <- done
 }
 
 /**
- * Compile
+ * Determine the document root for the server
  */
-func runCompile(options *Options, outbase string, args []string) {
+func serverRoot(options *Options, args []string) string {
+  if len(args) > 0 {
+    return args[0]
+  }else if options.Server.Root != "" {
+    return options.Server.Root
+  }else{
+    return "."
+  }
+}
+
+/**
+ * Build resources and export headers. When staging, the output is built in a
+ * staging directory which replaces the output base only if everything succeeds.
+ */
+func runBuild(options *Options, outbase, headers string, args []string) int {
+  target := outbase
   
-  if err := os.MkdirAll(outbase, 0755); err != nil {
-    fmt.Println(err)
-    return
+  if options.Build.Stage {
+    staging, err := prepareStaging(outbase)
+    if err != nil {
+      fmt.Println(err)
+      return EXIT_IO
+    }
+    target = staging
+  }
+  
+  status := runCompile(options, outbase, target, args)
+  if status == EXIT_SUCCESS && headers != "" {
+    status = runExportHeaders(options, target, headers)
+  }
+  
+  if options.Build.Stage {
+    if status != EXIT_SUCCESS {
+      os.RemoveAll(target) // the existing output is left as it was
+    }else if err := commitStaging(outbase, target); err != nil {
+      fmt.Println(err)
+      return EXIT_IO
+    }
+  }
+  
+  return status
+}
+
+/**
+ * Compile. Output is written to the target, which is the output base itself unless
+ * we're staging.
+ */
+func runCompile(options *Options, outbase, target string, args []string) int {
+  var diagnostics Diagnostics
+  start := time.Now()
+  summary := &buildSummary{}
+  
+  // assets are only measured if we need to know how big they are
+  var report *buildReport
+  if options.Build.Report || len(options.Budgets) > 0 {
+    report = newBuildReport(target)
+  }
+  
+  defer func() {
+    summary.Duration = time.Since(start).Seconds()
+    summary.Errors = diagnostics.Count(SeverityError)
+    summary.Warnings = diagnostics.Count(SeverityWarning)
+    extra := map[string]interface{}{"summary": summary}
+    if options.Build.Report {
+      extra["report"] = report.Assets
+      if options.Build.Format != DIAGNOSTIC_FORMAT_JSON {
+        report.print(os.Stdout)
+      }
+    }
+    printDiagnostics(options.Build.Format, diagnostics, extra)
+    if options.Build.Format != DIAGNOSTIC_FORMAT_JSON {
+      fmt.Println(summary)
+    }
+  }()
+  
+  if err := os.MkdirAll(target, 0755); err != nil {
+    diagnostics = append(diagnostics, NewDiagnostic(SeverityError, target, err))
+    return EXIT_IO
+  }
+  
+  // none of our builds are inputs, in case they're under the input base
+  outputs, err := outputPaths(outbase)
+  if err != nil {
+    diagnostics = append(diagnostics, NewDiagnostic(SeverityError, outbase, err))
+    return EXIT_IO
   }
   
   for _, f := range args {
@@ -268,53 +610,121 @@ func runCompile(options *Options, outbase string, args []string) {
     var err error
     
     if input, err = os.Open(f); err != nil {
-      fmt.Println(err)
-      return
+      diagnostics = append(diagnostics, NewDiagnostic(SeverityError, f, err))
+      if options.Build.KeepGoing { continue }else{ break }
     }
     
     defer input.Close()
     
     if fstat, err = input.Stat(); err != nil {
-      fmt.Println(err)
-      return
+      diagnostics = append(diagnostics, NewDiagnostic(SeverityError, f, err))
+      if options.Build.KeepGoing { continue }else{ break }
     }
     
+    var w *Walker
     if fstat.Mode().IsDir() {
-      w := &Walker{f, outbase}
-      if err := filepath.Walk(input.Name(), w.compileResource); err != nil {
-        fmt.Println(err)
-        return
-      }
+      w = &Walker{inbase:f, outbase:target, outputs:outputs, summary:summary, report:report, keepGoing:options.Build.KeepGoing}
     }else{
-      w := &Walker{filepath.Dir(f), outbase}
-      if err := filepath.Walk(input.Name(), w.compileResource); err != nil {
-        fmt.Println(err)
-        return
-      }
+      w = &Walker{inbase:filepath.Dir(f), outbase:target, outputs:outputs, summary:summary, report:report, keepGoing:options.Build.KeepGoing}
+    }
+    
+    err = filepath.Walk(input.Name(), w.compileResource)
+    diagnostics = append(diagnostics, w.diagnostics...)
+    if err == errBuildStopped {
+      break
+    }else if err != nil {
+      diagnostics = append(diagnostics, NewDiagnostic(SeverityError, f, err))
+      if !options.Build.KeepGoing { break }
     }
     
   }
   
+  if report != nil {
+    diagnostics = append(diagnostics, report.checkBudgets(options.Budgets)...)
+  }
+  
+  return diagnostics.ExitStatus()
+}
+
+/**
+ * A summary of the resources processed by a build
+ */
+type buildSummary struct {
+  Compiled  int       `json:"compiled"`
+  Copied    int       `json:"copied"`
+  Skipped   int       `json:"skipped"`
+  Failed    int       `json:"failed"`
+  Errors    int       `json:"errors"`
+  Warnings  int       `json:"warnings"`
+  Duration  float64   `json:"duration"`
+}
+
+/**
+ * Count a processed resource
+ */
+func (s *buildSummary) add(result string) {
+  switch result {
+    case RESOURCE_COMPILED:
+      s.Compiled++
+    case RESOURCE_COPIED:
+      s.Copied++
+    case RESOURCE_SKIPPED:
+      s.Skipped++
+    case RESOURCE_FAILED:
+      s.Failed++
+  }
+}
+
+/**
+ * Describe the summary
+ */
+func (s *buildSummary) String() string {
+  return fmt.Sprintf("Compiled %d, copied %d, skipped %d, failed %d in %v; %s", s.Compiled, s.Copied, s.Skipped, s.Failed, time.Duration(s.Duration * float64(time.Second)).Round(time.Millisecond), Diagnostics{}.summarize(s.Errors, s.Warnings))
+}
+
+/**
+ * Export response headers
+ */
+func runExportHeaders(options *Options, outbase, format string) int {
+  
+  rules, err := newHeaderRules(options.Headers, options.Variables)
+  if err != nil {
+    fmt.Println(err)
+    return EXIT_CONFIG
+  }
+  
+  if outpath, err := exportHeaders(rules, format, outbase); err != nil {
+    fmt.Println(err)
+    if _, ok := err.(*os.PathError); ok {
+      return EXIT_IO
+    }else{
+      return EXIT_CONFIG
+    }
+  }else if !options.GetFlag(OptionsFlagQuiet) {
+    fmt.Printf("[h] %s\n", outpath)
+  }
+  
+  return EXIT_SUCCESS
 }
 
 /**
  * Process a resource
  */
-func processResource(context *Context, info os.FileInfo, inpath, outpath string, input *os.File, output io.Writer) error {
+func processResource(context *Context, info os.FileInfo, inpath, outpath string, input *os.File, output io.Writer) (string, error) {
   if CanCompile(context, inpath) {
     if !SharedOptions().ShouldExclude(inpath) {
       if !SharedOptions().GetFlag(OptionsFlagQuiet) { fmt.Printf("[+] %s\n", inpath) }
-      return compileResource(context, info, inpath, outpath, input, output)
+      return RESOURCE_COMPILED, compileResource(context, info, inpath, outpath, input, output)
     }else{
       if !SharedOptions().GetFlag(OptionsFlagQuiet) { fmt.Printf("[ ] %s\n", inpath) }
-      return nil
+      return RESOURCE_SKIPPED, nil
     }
   }else if SharedOptions().Unmanaged.ShouldCopy(inpath) {
     if !SharedOptions().GetFlag(OptionsFlagQuiet) { fmt.Printf("[~] %s\n", inpath) }
-    return copyResource(context, info, inpath, outpath, input, output)
+    return RESOURCE_COPIED, copyResource(context, info, inpath, outpath, input, output)
   }else{
     if !SharedOptions().GetFlag(OptionsFlagQuiet) { fmt.Printf("[ ] %s\n", inpath) }
-    return nil
+    return RESOURCE_SKIPPED, nil
   }
 }
 
@@ -335,22 +745,30 @@ func compileResource(context *Context, info os.FileInfo, inpath, outpath string,
     return err
   }
   
-  // if we aren't provided an explicit output stream, open the output file and use that
+  // if we aren't provided an explicit output stream, create the output file and use that
+  var outfile *outputFile
   if output == nil {
-    outfile, err := os.OpenFile(outpath, os.O_WRONLY | os.O_CREATE | os.O_TRUNC, 0644)
-    if err != nil {
+    if outfile, err = createOutput(outpath); err != nil {
       return err
-    }else{
-      defer outfile.Close()
     }
     output = outfile
   }
   
   err = compiler.Compile(context, inpath, outpath, input, output)
-  if err != nil {
+  if err = context.Failure(err); err != nil {
+    if outfile != nil {
+      outfile.Abort() // don't leave a partially compiled resource behind
+    }
     return err
   }
   
+  if outfile != nil {
+    if err := outfile.Commit(); err != nil {
+      return err
+    }
+    return compressResource(SharedOptions().Build, outpath)
+  }
+  
   return nil
 }
 
@@ -359,20 +777,73 @@ func compileResource(context *Context, info os.FileInfo, inpath, outpath string,
  */
 func copyResource(context *Context, info os.FileInfo, inpath, outpath string, input *os.File, output io.Writer) error {
   
-  // if we aren't provided an explicit output stream, open the output file and use that
+  // if we aren't provided an explicit output stream, create the output file and use that
+  var outfile *outputFile
   if output == nil {
-    outfile, err := os.OpenFile(outpath, os.O_WRONLY | os.O_CREATE | os.O_TRUNC, 0644)
-    if err != nil {
+    var err error
+    if outfile, err = createOutput(outpath); err != nil {
       return err
-    }else{
-      defer outfile.Close()
     }
     output = outfile
   }
   
   // copy our resource over
   if _, err := io.Copy(output, input); err != nil {
+    if outfile != nil {
+      outfile.Abort()
+    }
+    return err
+  }
+  
+  if outfile != nil {
+    if err := outfile.Commit(); err != nil {
+      return err
+    }
+    return compressResource(SharedOptions().Build, outpath)
+  }
+  
+  return nil
+}
+
+/**
+ * Write precompressed siblings of an output resource, e.g., 'site.css.gz', if it is
+ * a text resource and is large enough to be worth compressing.
+ */
+func compressResource(options BuildOptions, outpath string) error {
+  if len(options.Compress) < 1 {
+    return nil
+  }
+  
+  mimetype, ok := MIMETYPES[filepath.Ext(outpath)]
+  if !ok {
+    mimetype = mime.TypeByExtension(filepath.Ext(outpath))
+  }
+  if !isCompressible(mimetype) {
+    return nil
+  }
+  
+  data, err := ioutil.ReadFile(outpath)
+  if err != nil {
     return err
+  }else if int64(len(data)) < options.CompressThreshold {
+    return nil
+  }
+  
+  for _, e := range options.Compress {
+    outfile, err := createOutput(outpath + ENCODING_EXTENSIONS[e])
+    if err != nil {
+      return err
+    }
+    encoder := newEncoder(e, outfile)
+    if _, err = encoder.Write(data); err == nil {
+      err = encoder.Close()
+    }
+    if err != nil {
+      outfile.Abort()
+      return err
+    }else if err = outfile.Commit(); err != nil {
+      return err
+    }
   }
   
   return nil
@@ -382,8 +853,13 @@ func copyResource(context *Context, info os.FileInfo, inpath, outpath string, in
  * Walk context
  */
 type Walker struct {
-  inbase    string
-  outbase   string
+  inbase      string
+  outbase     string
+  outputs     []string
+  diagnostics Diagnostics
+  summary     *buildSummary
+  report      *buildReport
+  keepGoing   bool
 }
 
 /**
@@ -415,18 +891,46 @@ func (w Walker) relocateResource(path string) (string, error) {
   return filepath.Join(absout, abspath[len(absin)+1:]), nil
 }
 
+/**
+ * Determine whether a path is one of our output directories
+ */
+func (w Walker) isOutput(path string) bool {
+  abspath, err := filepath.Abs(path)
+  if err != nil {
+    return false
+  }
+  for _, e := range w.outputs {
+    if abspath == e {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Note an I/O error. Unless we're keeping going the build is stopped.
+ */
+func (w *Walker) stop(path string, err error) error {
+  w.diagnostics = append(w.diagnostics, NewDiagnostic(SeverityError, path, err))
+  if w.keepGoing {
+    return nil
+  }else{
+    return errBuildStopped
+  }
+}
+
 /**
  * Compile a resource
  */
-func (w Walker) compileResource(path string, info os.FileInfo, err error) error {
-  hidden := info.Name() != "." && info.Name()[0] == '.'
-  
+func (w *Walker) compileResource(path string, info os.FileInfo, err error) error {
   if err != nil {
-    return err
+    return w.stop(path, err)
   }else if path == w.inbase {
     return nil // just descend into the input base path
   }
   
+  hidden := info.Name() != "." && info.Name()[0] == '.'
+  
   outpath, err := w.relocateResource(path)
   if err != nil {
     return err
@@ -435,10 +939,13 @@ func (w Walker) compileResource(path string, info os.FileInfo, err error) error
   if info.Mode().IsDir() {
     if hidden {
       return filepath.SkipDir // skip hidden directories
-    }else if strings.HasPrefix(path, w.outbase) {
+    }else if w.isOutput(path) {
       return filepath.SkipDir // skip the tree under the output root
     }else if err := os.Mkdir(outpath, 0755); err != nil && !os.IsExist(err) {
-      return err // could not create output directory
+      if err = w.stop(path, err); err == nil {
+        return filepath.SkipDir // could not create output directory, keep going without it
+      }
+      return err
     }else{
       return nil // just descend
     }
@@ -450,12 +957,34 @@ func (w Walker) compileResource(path string, info os.FileInfo, err error) error
   
   input, err := os.Open(path)
   if err != nil {
-    return err
+    w.summary.add(RESOURCE_FAILED)
+    return w.stop(path, err)
   }else{
     defer input.Close()
   }
   
-  return processResource(NewContext(), info, input.Name(), outpath, input, nil)
+  // compile problems are collected and we move on to the next resource; I/O
+  // problems stop the build unless we've been asked to keep going
+  context := NewContext()
+  result, err := processResource(context, info, input.Name(), outpath, input, nil)
+  if err != nil {
+    d := diagnosticsForError(SeverityError, path, err)
+    w.diagnostics = append(w.diagnostics, d...)
+    w.summary.add(RESOURCE_FAILED)
+    if !w.keepGoing && d.ExitStatus() == EXIT_IO {
+      return errBuildStopped
+    }
+  }else{
+    w.diagnostics = append(w.diagnostics, context.Diagnostics()...)
+    w.summary.add(result)
+    if w.report != nil && (result == RESOURCE_COMPILED || result == RESOURCE_COPIED) {
+      if err := w.report.add(context, info, input.Name(), outpath, result); err != nil {
+        w.diagnostics = append(w.diagnostics, NewDiagnostic(SeverityWarning, path, fmt.Errorf("Could not measure resource: %v", err)))
+      }
+    }
+  }
+  
+  return nil
 }
 
 
@@ -0,0 +1,507 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+package main
+
+import (
+  "os"
+  "io"
+  "fmt"
+  "path"
+  "sort"
+  "regexp"
+  "strings"
+  "strconv"
+  "io/ioutil"
+  "path/filepath"
+  "text/template"
+  "text/template/parse"
+)
+
+import "ejs"
+
+const (
+  DEPS_FORMAT_DOT = "dot"
+)
+
+/**
+ * Sass imports, and the quoted resources each of them names
+ */
+var sassImportPattern = regexp.MustCompile(`(?m)^[ \t]*@import\s+([^;]+);`)
+var sassImportTarget = regexp.MustCompile(`"([^"]+)"|'([^']+)'`)
+
+/**
+ * An import of one file by another. The target is empty if the import cannot be
+ * resolved.
+ */
+type depsImport struct {
+  Import    string        `json:"import"`
+  Line      int           `json:"line,omitempty"`
+  Target    string        `json:"target,omitempty"`
+  Remote    bool          `json:"remote,omitempty"`
+  path      string
+}
+
+/**
+ * A file in the dependency graph
+ */
+type depsFile struct {
+  Path      string        `json:"path"`
+  Kind      string        `json:"kind"`
+  Entry     bool          `json:"entry"`
+  Imports   []*depsImport `json:"imports"`
+  Bundles   []string      `json:"bundles,omitempty"`
+  abs       string
+  importers int
+  scanned   bool
+}
+
+/**
+ * The dependency graph of a source tree, as the compilers see it
+ */
+type depsGraph struct {
+  files       map[string]*depsFile
+  diagnostics Diagnostics
+}
+
+/**
+ * Build the dependency graph for every resource under the provided root
+ */
+func newDepsGraph(root string) (*depsGraph, error) {
+  g := &depsGraph{files: make(map[string]*depsFile)}
+  
+  err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+    if err != nil {
+      return err
+    }
+    hidden := p != root && strings.HasPrefix(info.Name(), ".")
+    if info.IsDir() {
+      if hidden { return filepath.SkipDir }else{ return nil }
+    }else if hidden {
+      return nil
+    }
+    switch filepath.Ext(p) {
+      case ".ejs", ".scss", ".ghtml":
+        if abs, err := filepath.Abs(p); err != nil {
+          return err
+        }else{
+          g.scan(g.file(abs))
+        }
+    }
+    return nil
+  })
+  if err != nil {
+    return nil, err
+  }
+  
+  g.resolveBundles()
+  return g, nil
+}
+
+/**
+ * Obtain the node for a file, creating it if necessary
+ */
+func (g *depsGraph) file(abs string) *depsFile {
+  if f, ok := g.files[abs]; ok {
+    return f
+  }
+  kind := "url"
+  if !isRemoteImport(abs) {
+    kind = strings.TrimPrefix(filepath.Ext(abs), ".")
+  }
+  f := &depsFile{Path: displayPath(abs), Kind: kind, abs: abs}
+  g.files[abs] = f
+  return f
+}
+
+/**
+ * Note a problem
+ */
+func (g *depsGraph) report(severity, code, file string, line int, err error) {
+  d := NewDiagnostic(severity, file, err)
+  d.Code = code
+  if line > 0 && d.Line == 0 {
+    d.Line = line
+  }
+  g.diagnostics = append(g.diagnostics, d)
+}
+
+/**
+ * Scan a file for the files it imports, and those files for theirs
+ */
+func (g *depsGraph) scan(f *depsFile) {
+  if f.scanned || f.Kind == "url" {
+    return
+  }else{
+    f.scanned = true
+  }
+  
+  source, err := ioutil.ReadFile(f.abs)
+  if err != nil {
+    return // missing files are reported by whoever imports them
+  }
+  
+  switch f.Kind {
+    case "ejs":
+      g.scanEJS(f, string(source))
+    case "scss":
+      g.scanSass(f, string(source))
+    case "ghtml":
+      g.scanTemplate(f, string(source))
+  }
+  
+  for _, e := range f.Imports {
+    if e.path != "" {
+      g.scan(g.file(e.path))
+    }
+  }
+  
+}
+
+/**
+ * Add an import to a file
+ */
+func (g *depsGraph) addImport(f *depsFile, resource string, line int, target string) {
+  i := &depsImport{Import: resource, Line: line, Remote: isRemoteImport(resource), path: target}
+  if target != "" {
+    t := g.file(target)
+    t.importers++
+    i.Target = t.Path
+  }
+  f.Imports = append(f.Imports, i)
+}
+
+/**
+ * Scan EJS imports. Imports are resolved the same way the EJS compiler does.
+ */
+func (g *depsGraph) scanEJS(f *depsFile, source string) {
+  scanner := newEJSScanner(SharedOptions(), f.Path, source)
+  for {
+    toks, err := scanner.Token()
+    if err != nil {
+      g.report(SeverityError, DiagnosticCodeSyntax, f.Path, 0, err)
+      return
+    }
+    for _, tok := range toks {
+      switch tok.Type {
+        case ejs.TokenTypeEOF:
+          return
+        case ejs.TokenTypeImport:
+          line := scanner.DirectiveLine() + 1
+          if isRemoteImport(tok.Text) {
+            g.addImport(f, tok.Text, line, tok.Text)
+          }else if target := filepath.Join(filepath.Dir(f.abs), filepath.FromSlash(tok.Text)); fileExists(target) {
+            g.addImport(f, tok.Text, line, target)
+          }else{
+            g.addImport(f, tok.Text, line, "")
+            g.report(SeverityError, DiagnosticCodeImport, f.Path, 0, scanner.DirectiveErrorf("Import not found: %s", tok.Text))
+          }
+      }
+    }
+  }
+}
+
+/**
+ * Scan Sass imports. Imports of plain CSS, which Sass leaves alone, are ignored;
+ * others are resolved to a file or partial relative to the importing file.
+ */
+func (g *depsGraph) scanSass(f *depsFile, source string) {
+  for _, m := range sassImportPattern.FindAllStringSubmatchIndex(source, -1) {
+    line := strings.Count(source[:m[2]], "\n") + 1
+    for _, t := range sassImportTarget.FindAllStringSubmatch(source[m[2]:m[3]], -1) {
+      resource := t[1] + t[2]
+      if isRemoteImport(resource) || strings.HasPrefix(resource, "//") || strings.HasSuffix(resource, ".css") {
+        continue
+      }
+      if target := resolveSassImport(filepath.Dir(f.abs), resource); target != "" {
+        g.addImport(f, resource, line, target)
+      }else{
+        g.addImport(f, resource, line, "")
+        g.report(SeverityError, DiagnosticCodeImport, f.Path, line, fmt.Errorf("Import not found: %s", resource))
+      }
+    }
+  }
+}
+
+/**
+ * Scan a template. Templates cannot include other files, so we only check that
+ * every template they use is defined.
+ */
+func (g *depsGraph) scanTemplate(f *depsFile, source string) {
+  t, err := template.New(f.Path).Parse(source)
+  if err != nil {
+    g.report(SeverityError, DiagnosticCodeSyntax, f.Path, 0, err)
+    return
+  }
+  
+  defined := make(map[string]bool)
+  for _, e := range t.Templates() {
+    defined[e.Name()] = true
+  }
+  
+  var walk func(parse.Node)
+  walk = func(n parse.Node) {
+    switch c := n.(type) {
+      case *parse.ListNode:
+        if c != nil {
+          for _, e := range c.Nodes { walk(e) }
+        }
+      case *parse.IfNode:
+        walk(c.List); walk(c.ElseList)
+      case *parse.RangeNode:
+        walk(c.List); walk(c.ElseList)
+      case *parse.WithNode:
+        walk(c.List); walk(c.ElseList)
+      case *parse.TemplateNode:
+        if !defined[c.Name] {
+          g.report(SeverityError, DiagnosticCodeImport, f.Path, strings.Count(source[:int(c.Pos)], "\n") + 1, fmt.Errorf("Template is not defined: %s", c.Name))
+        }
+    }
+  }
+  
+  for _, e := range t.Templates() {
+    if e.Tree != nil {
+      walk(e.Tree.Root)
+    }
+  }
+  
+}
+
+/**
+ * Determine which bundles include each file and note problems that are only
+ * apparent from the whole graph: partials nobody imports and files bundled more
+ * than once.
+ */
+func (g *depsGraph) resolveBundles() {
+  for _, f := range g.sorted() {
+    if !f.scanned || !g.isEntry(f) {
+      continue
+    }else if f.Kind == "scss" && strings.HasPrefix(path.Base(f.abs), "_") {
+      g.report(SeverityWarning, DiagnosticCodeUnused, f.Path, 0, fmt.Errorf("Partial is never imported"))
+      continue
+    }
+    
+    f.Entry = true
+    visited := map[string]bool{f.abs: true}
+    var walk func(*depsFile)
+    walk = func(n *depsFile) {
+      for _, e := range n.Imports {
+        if e.path == "" || visited[e.path] {
+          continue
+        }
+        visited[e.path] = true
+        t := g.files[e.path]
+        t.Bundles = append(t.Bundles, f.Path)
+        walk(t)
+      }
+    }
+    walk(f)
+  }
+  
+  // each EJS bundle is compiled on its own, so a file they share is duplicated
+  for _, f := range g.sorted() {
+    var bundles []string
+    for _, e := range f.Bundles {
+      if strings.HasSuffix(e, ".ejs") {
+        bundles = append(bundles, e)
+      }
+    }
+    if len(bundles) > 1 {
+      g.report(SeverityWarning, DiagnosticCodeDuplicated, f.Path, 0, fmt.Errorf("Imported by %d bundles, which will each include a copy: %s", len(bundles), strings.Join(bundles, ", ")))
+    }
+  }
+  
+}
+
+/**
+ * Determine whether a file is built on its own. Every EJS file which isn't excluded
+ * is compiled into its own bundle, even if it's also imported; anything else is only
+ * an entry point if nothing imports it.
+ */
+func (g *depsGraph) isEntry(f *depsFile) bool {
+  if f.Kind == "ejs" {
+    return !SharedOptions().ShouldExclude(f.Path)
+  }else{
+    return f.importers < 1
+  }
+}
+
+/**
+ * Obtain every file, ordered by path
+ */
+func (g *depsGraph) sorted() []*depsFile {
+  files := make([]*depsFile, 0, len(g.files))
+  for _, e := range g.files {
+    files = append(files, e)
+  }
+  sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+  return files
+}
+
+/**
+ * Print the graph as a tree for each entry point. A file which appears more than
+ * once in a tree is only expanded the first time.
+ */
+func (g *depsGraph) printTree(writer io.Writer) {
+  for _, f := range g.sorted() {
+    if !f.Entry {
+      continue
+    }
+    fmt.Fprintln(writer, f.Path)
+    g.printImports(writer, f, "", map[string]bool{f.abs: true}, map[string]bool{})
+  }
+}
+
+/**
+ * Print the imports of a file in a tree
+ */
+func (g *depsGraph) printImports(writer io.Writer, f *depsFile, prefix string, stack, seen map[string]bool) {
+  for i, e := range f.Imports {
+    branch, indent := "\u251c\u2500\u2500 ", "\u2502   "
+    if i == len(f.Imports) - 1 {
+      branch, indent = "\u2514\u2500\u2500 ", "    "
+    }
+    
+    if e.path == "" {
+      fmt.Fprintf(writer, "%s%s%s (missing)\n", prefix, branch, e.Import)
+      continue
+    }
+    
+    t := g.files[e.path]
+    label := t.Path
+    if e.Remote {
+      label += " (remote)"
+    }
+    
+    if stack[e.path] {
+      fmt.Fprintf(writer, "%s%s%s (cycle)\n", prefix, branch, label)
+    }else if seen[e.path] && len(t.Imports) > 0 {
+      fmt.Fprintf(writer, "%s%s%s (see above)\n", prefix, branch, label)
+    }else{
+      fmt.Fprintf(writer, "%s%s%s\n", prefix, branch, label)
+      seen[e.path] = true
+      stack[e.path] = true
+      g.printImports(writer, t, prefix + indent, stack, seen)
+      delete(stack, e.path)
+    }
+  }
+}
+
+/**
+ * Print the graph in Graphviz DOT format. Entry points are boxes, missing imports
+ * are red and unused partials are dashed.
+ */
+func (g *depsGraph) printDOT(writer io.Writer) {
+  fmt.Fprintln(writer, "digraph dependencies {")
+  fmt.Fprintln(writer, "  rankdir=LR;")
+  for _, f := range g.sorted() {
+    if f.Entry {
+      fmt.Fprintf(writer, "  %s [shape=box];\n", strconv.Quote(f.Path))
+    }else if f.scanned && f.importers < 1 {
+      fmt.Fprintf(writer, "  %s [style=dashed];\n", strconv.Quote(f.Path))
+    }
+    for _, e := range f.Imports {
+      if e.path == "" {
+        fmt.Fprintf(writer, "  %s [color=red, fontcolor=red];\n", strconv.Quote(e.Import))
+        fmt.Fprintf(writer, "  %s -> %s [color=red];\n", strconv.Quote(f.Path), strconv.Quote(e.Import))
+      }else{
+        fmt.Fprintf(writer, "  %s -> %s;\n", strconv.Quote(f.Path), strconv.Quote(e.Target))
+      }
+    }
+  }
+  fmt.Fprintln(writer, "}")
+}
+
+/**
+ * Determine whether an import refers to a remote resource
+ */
+func isRemoteImport(resource string) bool {
+  return strings.HasPrefix(resource, "http://") || strings.HasPrefix(resource, "https://")
+}
+
+/**
+ * Resolve a Sass import relative to a directory. An import may omit the '.scss'
+ * extension and the leading '_' of a partial.
+ */
+func resolveSassImport(dir, resource string) string {
+  rdir, base := path.Split(resource)
+  
+  var names []string
+  if path.Ext(base) == ".scss" {
+    names = []string{base, "_"+ base}
+  }else{
+    names = []string{base +".scss", "_"+ base +".scss"}
+  }
+  
+  for _, e := range names {
+    if p := filepath.Join(dir, filepath.FromSlash(rdir), e); fileExists(p) {
+      return p
+    }
+  }
+  
+  return ""
+}
+
+/**
+ * Determine whether a regular file exists
+ */
+func fileExists(p string) bool {
+  info, err := os.Stat(p)
+  return err == nil && !info.IsDir()
+}
+
+/**
+ * Print the dependency graph of a source tree
+ */
+func runDeps(options *Options, args []string) int {
+  
+  g, err := newDepsGraph(serverRoot(options, args))
+  if err != nil {
+    fmt.Println(err)
+    return EXIT_IO
+  }
+  
+  switch options.Build.Format {
+    case DIAGNOSTIC_FORMAT_JSON:
+      printDiagnostics(DIAGNOSTIC_FORMAT_JSON, g.diagnostics, map[string]interface{}{"files": g.sorted()})
+    case DEPS_FORMAT_DOT:
+      g.printDOT(os.Stdout)
+      for _, e := range g.diagnostics {
+        fmt.Fprintln(os.Stderr, e.String())
+      }
+    default:
+      g.printTree(os.Stdout)
+      if len(g.diagnostics) > 0 {
+        fmt.Println()
+        printDiagnostics(DIAGNOSTIC_FORMAT_TEXT, g.diagnostics, nil)
+      }
+  }
+  
+  return g.diagnostics.ExitStatus()
+}
@@ -0,0 +1,237 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+package main
+
+import (
+  "os"
+  "fmt"
+  "io/ioutil"
+  "path/filepath"
+)
+
+const (
+  BUILD_STAGING_SUFFIX  = ".staging"
+  BUILD_PREVIOUS_SUFFIX = ".previous"
+)
+
+/**
+ * Returned when rolling back a build which has no previous build
+ */
+var errNoPreviousBuild = fmt.Errorf("There is no previous build to roll back to")
+
+/**
+ * Returned when paths cannot be exchanged atomically on this platform
+ */
+var errExchangeUnsupported = fmt.Errorf("Paths cannot be exchanged atomically on this platform")
+
+/**
+ * An output file which is written to a temporary file alongside its destination and
+ * only renamed into place once it has been completely written, so an output is never
+ * left truncated by a failed or interrupted build.
+ */
+type outputFile struct {
+  *os.File
+  path  string
+}
+
+/**
+ * Create an output file for the provided path
+ */
+func createOutput(outpath string) (*outputFile, error) {
+  file, err := ioutil.TempFile(filepath.Dir(outpath), "."+ filepath.Base(outpath) +".*.tmp")
+  if err != nil {
+    return nil, err
+  }
+  return &outputFile{file, outpath}, nil
+}
+
+/**
+ * Finish writing the output and move it into place
+ */
+func (f *outputFile) Commit() error {
+  if err := f.File.Close(); err != nil {
+    os.Remove(f.File.Name())
+    return err
+  }else if err := os.Chmod(f.File.Name(), 0644); err != nil {
+    os.Remove(f.File.Name())
+    return err
+  }else if err := os.Rename(f.File.Name(), f.path); err != nil {
+    os.Remove(f.File.Name())
+    return err
+  }
+  return nil
+}
+
+/**
+ * Discard the output, leaving whatever was previously at its path untouched
+ */
+func (f *outputFile) Abort() {
+  f.File.Close()
+  os.Remove(f.File.Name())
+}
+
+/**
+ * Write an output file in one go
+ */
+func writeOutput(outpath string, data []byte) error {
+  file, err := createOutput(outpath)
+  if err != nil {
+    return err
+  }
+  if _, err := file.Write(data); err != nil {
+    file.Abort()
+    return err
+  }
+  return file.Commit()
+}
+
+/**
+ * Obtain the absolute paths of every directory a build of the output base may
+ * occupy: the output base itself, its staging directory and the previous build.
+ */
+func outputPaths(outbase string) ([]string, error) {
+  abs, err := filepath.Abs(outbase)
+  if err != nil {
+    return nil, err
+  }
+  return []string{abs, abs + BUILD_STAGING_SUFFIX, abs + BUILD_PREVIOUS_SUFFIX}, nil
+}
+
+/**
+ * Prepare an empty staging directory for a build which will replace the output base
+ */
+func prepareStaging(outbase string) (string, error) {
+  staging := filepath.Clean(outbase) + BUILD_STAGING_SUFFIX
+  if err := os.RemoveAll(staging); err != nil {
+    return "", fmt.Errorf("Could not remove stale staging directory: %v", err)
+  }
+  return staging, nil
+}
+
+/**
+ * Replace the output base with a completed staging directory. Whatever was at the
+ * output base is kept alongside it as the previous build so it can be rolled back.
+ * 
+ * Where the platform supports it the two are exchanged atomically, so the output
+ * base always exists. Otherwise the output base is moved aside before the staged
+ * build is moved into its place, so it briefly does not exist in between.
+ */
+func commitStaging(outbase, staging string) error {
+  outbase = filepath.Clean(outbase)
+  previous := outbase + BUILD_PREVIOUS_SUFFIX
+  
+  if err := os.RemoveAll(previous); err != nil {
+    return fmt.Errorf("Could not remove previous build: %v", err)
+  }
+  
+  if _, err := os.Stat(outbase); err == nil {
+    if err := exchangePaths(staging, outbase); err == nil {
+      // the staging directory now holds the build we replaced
+      if err := os.Rename(staging, previous); err != nil {
+        return fmt.Errorf("Could not keep previous build: %v", err)
+      }
+      return nil
+    }else if err != errExchangeUnsupported {
+      return fmt.Errorf("Could not move staged build into place: %v", err)
+    }
+  }
+  
+  var moved bool
+  if _, err := os.Stat(outbase); err == nil {
+    if err := os.Rename(outbase, previous); err != nil {
+      return fmt.Errorf("Could not move current build aside: %v", err)
+    }
+    moved = true
+  }else if !os.IsNotExist(err) {
+    return err
+  }
+  
+  if err := os.Rename(staging, outbase); err != nil {
+    if moved {
+      os.Rename(previous, outbase) // put the current build back
+    }
+    return fmt.Errorf("Could not move staged build into place: %v", err)
+  }
+  
+  return nil
+}
+
+/**
+ * Exchange the output base with the previous build. Rolling back twice restores the
+ * original build.
+ */
+func rollbackBuild(outbase string) error {
+  outbase = filepath.Clean(outbase)
+  previous := outbase + BUILD_PREVIOUS_SUFFIX
+  
+  if _, err := os.Stat(previous); os.IsNotExist(err) {
+    return errNoPreviousBuild
+  }else if err != nil {
+    return err
+  }
+  
+  if _, err := os.Stat(outbase); err == nil {
+    if err := exchangePaths(previous, outbase); err != errExchangeUnsupported {
+      return err
+    }
+  }
+  
+  staging, err := prepareStaging(outbase)
+  if err != nil {
+    return err
+  }
+  if err := os.Rename(previous, staging); err != nil {
+    return err
+  }
+  if err := commitStaging(outbase, staging); err != nil {
+    os.Rename(staging, previous)
+    return err
+  }
+  
+  return nil
+}
+
+/**
+ * Roll back the output base to the previous build
+ */
+func runRollback(options *Options, outbase string) int {
+  if err := rollbackBuild(outbase); err != nil {
+    fmt.Println(err)
+    if err == errNoPreviousBuild {
+      return EXIT_CONFIG
+    }else{
+      return EXIT_IO
+    }
+  }
+  if !options.GetFlag(OptionsFlagQuiet) {
+    fmt.Printf("Rolled back %s to the previous build\n", outbase)
+  }
+  return EXIT_SUCCESS
+}
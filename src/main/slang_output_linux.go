@@ -0,0 +1,90 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+package main
+
+import (
+  "runtime"
+  "syscall"
+  "unsafe"
+)
+
+/**
+ * The renameat2 system call, which is not defined by the syscall package on every
+ * architecture
+ */
+var renameat2Traps = map[string]uintptr{
+  "386":      353,
+  "amd64":    316,
+  "arm":      382,
+  "arm64":    276,
+  "loong64":  276,
+  "mips64":   5311,
+  "mips64le": 5311,
+  "ppc64":    357,
+  "ppc64le":  357,
+  "riscv64":  276,
+  "s390x":    347,
+}
+
+const (
+  linuxATFDCWD        = -100
+  linuxRenameExchange = 1 << 1
+)
+
+/**
+ * Atomically exchange two paths, both of which must exist. If the kernel or the
+ * filesystem doesn't support this, errExchangeUnsupported is returned.
+ */
+func exchangePaths(a, b string) error {
+  trap, ok := renameat2Traps[runtime.GOARCH]
+  if !ok {
+    return errExchangeUnsupported
+  }
+  
+  pa, err := syscall.BytePtrFromString(a)
+  if err != nil {
+    return err
+  }
+  pb, err := syscall.BytePtrFromString(b)
+  if err != nil {
+    return err
+  }
+  
+  cwd := linuxATFDCWD
+  _, _, errno := syscall.Syscall6(trap, uintptr(cwd), uintptr(unsafe.Pointer(pa)), uintptr(cwd), uintptr(unsafe.Pointer(pb)), linuxRenameExchange, 0)
+  switch errno {
+    case 0:
+      return nil
+    case syscall.ENOSYS, syscall.EINVAL:
+      return errExchangeUnsupported
+    default:
+      return errno
+  }
+}
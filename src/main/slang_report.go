@@ -0,0 +1,326 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+package main
+
+import (
+  "os"
+  "io"
+  "fmt"
+  "path"
+  "sort"
+  "regexp"
+  "strings"
+  "io/ioutil"
+  "path/filepath"
+  "text/tabwriter"
+)
+
+/**
+ * The sizes of an asset produced by a build. Sizes are in bytes. The unminified
+ * size is only measured for resources which are minified.
+ */
+type assetReport struct {
+  Path        string        `json:"path"`
+  Type        string        `json:"type"`
+  Source      int64         `json:"source"`
+  Output      int64         `json:"output"`
+  Unminified  int64         `json:"unminified,omitempty"`
+  Gzip        int64         `json:"gzip"`
+  Imports     []ImportSize  `json:"imports,omitempty"`
+}
+
+/**
+ * The size of every asset produced by a build
+ */
+type buildReport struct {
+  outbase     string
+  Assets      []*assetReport  `json:"assets"`
+}
+
+/**
+ * Create a build report for assets written under the output base
+ */
+func newBuildReport(outbase string) *buildReport {
+  if abs, err := filepath.Abs(outbase); err == nil {
+    outbase = abs
+  }
+  return &buildReport{outbase: outbase}
+}
+
+/**
+ * Measure a resource which has been compiled or copied to the output path. The
+ * provided context is the one it was compiled with.
+ */
+func (r *buildReport) add(context *Context, info os.FileInfo, inpath, outpath, result string) error {
+  
+  // compiled resources usually end up somewhere other than their source
+  if result == RESOURCE_COMPILED {
+    if compiler, err := NewCompiler(context, inpath); err != nil {
+      return err
+    }else if outpath, err = compiler.OutputPath(context, outpath); err != nil {
+      return err
+    }
+  }
+  
+  data, err := ioutil.ReadFile(outpath)
+  if err != nil {
+    return err
+  }
+  
+  rel, err := filepath.Rel(r.outbase, outpath)
+  if err != nil {
+    rel = outpath
+  }
+  
+  asset := &assetReport{
+    Path: filepath.ToSlash(rel),
+    Type: assetType(outpath),
+    Source: info.Size(),
+    Output: int64(len(data)),
+    Imports: context.Imports(),
+  }
+  
+  if asset.Gzip, err = compressedSize(ENCODING_GZIP, data); err != nil {
+    return err
+  }
+  if result == RESOURCE_COMPILED && isMinified(inpath) {
+    if asset.Unminified, err = unminifiedSize(inpath); err != nil {
+      return err
+    }
+  }
+  
+  r.Assets = append(r.Assets, asset)
+  return nil
+}
+
+/**
+ * Check every asset against the size budgets which apply to it
+ */
+func (r *buildReport) checkBudgets(budgets []BudgetOptions) Diagnostics {
+  var diagnostics Diagnostics
+  
+  violation := func(asset *assetReport, format string, args ...interface{}) {
+    d := NewDiagnostic(SeverityError, asset.Path, fmt.Errorf(format, args...))
+    d.Code = DiagnosticCodeBudget
+    diagnostics = append(diagnostics, d)
+  }
+  
+  for _, b := range budgets {
+    expr := regexp.MustCompile(globToRegexp(b.Pattern))
+    for _, e := range r.Assets {
+      
+      // patterns without a directory match the asset name anywhere
+      subject := e.Path
+      if !strings.Contains(b.Pattern, "/") {
+        subject = path.Base(e.Path)
+      }
+      if !expr.MatchString(subject) {
+        continue
+      }
+      
+      if b.Size > 0 && e.Output > b.Size {
+        violation(e, "Size %s exceeds the budget of %s for %s", formatSize(e.Output), formatSize(b.Size), b.Pattern)
+      }
+      if b.Gzip > 0 && e.Gzip > b.Gzip {
+        violation(e, "Gzipped size %s exceeds the budget of %s for %s", formatSize(e.Gzip), formatSize(b.Gzip), b.Pattern)
+      }
+      
+    }
+  }
+  
+  return diagnostics
+}
+
+/**
+ * Print the report as a table, grouped by type and largest first, followed by the
+ * share each import contributed to each bundle.
+ */
+func (r *buildReport) print(writer io.Writer) {
+  assets := make([]*assetReport, len(r.Assets))
+  copy(assets, r.Assets)
+  sort.SliceStable(assets, func(i, j int) bool {
+    if assets[i].Type != assets[j].Type {
+      return assets[i].Type < assets[j].Type
+    }else{
+      return assets[i].Output > assets[j].Output
+    }
+  })
+  
+  w := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+  fmt.Fprintln(w, "ASSET\tSOURCE\tOUTPUT\tMINIFIED\tGZIP")
+  
+  var group string
+  for _, e := range assets {
+    if e.Type != group {
+      group = e.Type
+      fmt.Fprintf(w, "[%s]\t\t\t\t\n", group)
+    }
+    saved := "-"
+    if e.Unminified > 0 {
+      saved = formatSize(e.Output - e.Unminified)
+    }
+    fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Path, formatSize(e.Source), formatSize(e.Output), saved, formatSize(e.Gzip))
+  }
+  w.Flush()
+  
+  for _, e := range assets {
+    if len(e.Imports) < 1 {
+      continue
+    }
+    
+    imports := make([]ImportSize, len(e.Imports))
+    copy(imports, e.Imports)
+    sort.SliceStable(imports, func(i, j int) bool { return imports[i].Size > imports[j].Size })
+    
+    // imports are measured as they are bundled, before the bundle is minified
+    bundled := e.Unminified
+    if bundled == 0 {
+      bundled = e.Output
+    }
+    own := bundled
+    for _, i := range imports {
+      own -= i.Size
+    }
+    
+    fmt.Fprintf(writer, "\n%s imports:\n", e.Path)
+    w := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+    for _, i := range imports {
+      fmt.Fprintf(w, "  %s\t%s\t%s\n", formatSize(i.Size), percent(i.Size, bundled), displayPath(i.File))
+    }
+    fmt.Fprintf(w, "  %s\t%s\t(own source)\n", formatSize(own), percent(own, bundled))
+    w.Flush()
+  }
+  
+}
+
+/**
+ * Determine the type of an asset, by which it is grouped
+ */
+func assetType(outpath string) string {
+  if ext := filepath.Ext(outpath); ext != "" {
+    return ext[1:]
+  }else{
+    return "other"
+  }
+}
+
+/**
+ * Determine whether a resource is minified when it is compiled
+ */
+func isMinified(inpath string) bool {
+  switch filepath.Ext(inpath) {
+    case ".scss", ".css":
+      return SharedOptions().Stylesheet.Minify
+    case ".ejs", ".js":
+      return SharedOptions().Javascript.Minify
+    default:
+      return false
+  }
+}
+
+/**
+ * Measure the size of a resource compiled without minification
+ */
+func unminifiedSize(inpath string) (int64, error) {
+  context := NewContext()
+  context.Options |= compilerOptionNoMinify
+  
+  compiler, err := NewCompiler(context, inpath)
+  if err != nil {
+    return 0, err
+  }
+  
+  input, err := os.Open(inpath)
+  if err != nil {
+    return 0, err
+  }else{
+    defer input.Close()
+  }
+  
+  counter := &countingWriter{Writer: ioutil.Discard}
+  if err := compiler.Compile(context, inpath, "", input, counter); err != nil {
+    return 0, err
+  }
+  
+  return counter.count, nil
+}
+
+/**
+ * Measure the compressed size of data
+ */
+func compressedSize(encoding string, data []byte) (int64, error) {
+  counter := &countingWriter{Writer: ioutil.Discard}
+  encoder := newEncoder(encoding, counter)
+  if _, err := encoder.Write(data); err != nil {
+    return 0, err
+  }else if err := encoder.Close(); err != nil {
+    return 0, err
+  }
+  return counter.count, nil
+}
+
+/**
+ * Format a size for display
+ */
+func formatSize(n int64) string {
+  switch {
+    case n < 0:
+      return "-"+ formatSize(-n)
+    case n < 1 << 10:
+      return fmt.Sprintf("%d B", n)
+    case n < 1 << 20:
+      return fmt.Sprintf("%.1f KB", float64(n) / (1 << 10))
+    default:
+      return fmt.Sprintf("%.1f MB", float64(n) / (1 << 20))
+  }
+}
+
+/**
+ * Format a share of a total as a percentage
+ */
+func percent(n, total int64) string {
+  if total < 1 {
+    return "-"
+  }
+  return fmt.Sprintf("%.0f%%", float64(n) / float64(total) * 100)
+}
+
+/**
+ * Display a path relative to the working directory if we can
+ */
+func displayPath(p string) string {
+  if wd, err := os.Getwd(); err != nil {
+    return p
+  }else if rel, err := filepath.Rel(wd, p); err != nil || strings.HasPrefix(rel, "..") {
+    return p
+  }else{
+    return rel
+  }
+}
@@ -0,0 +1,192 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+
+package main
+
+import (
+  "os"
+  "fmt"
+  "strings"
+)
+
+import (
+  "net/url"
+  "net/http"
+)
+
+/**
+ * Explain how the server would route a request for a URL
+ */
+func runRoute(options *Options, args []string) {
+  
+  if len(args) < 1 {
+    fmt.Println("Usage: slang route <url> [./docroot]")
+    return
+  }
+  
+  u, err := url.Parse(args[0])
+  if err != nil {
+    fmt.Printf("URL is not valid: %v\n", err)
+    return
+  }else if !strings.HasPrefix(u.Path, "/") {
+    u.Path = "/"+ u.Path
+  }
+  
+  server, err := NewServer(options, serverRoot(options, args[1:]))
+  if err != nil {
+    fmt.Println(err)
+    return
+  }
+  
+  request, err := http.NewRequest("GET", u.String(), nil)
+  if err != nil {
+    fmt.Println(err)
+    return
+  }
+  
+  fmt.Printf("GET %s\n", u.Path)
+  
+  if strings.HasPrefix(u.Path, SLANG_PREFIX) {
+    fmt.Printf("  This path is reserved by Slang and is served by the server itself.\n")
+    return
+  }
+  
+  if mock, _ := matchMockRoute(server.mocks, request); mock != nil {
+    fmt.Printf("  Answered by the mock endpoint: %s\n", mock.options.Path)
+    return
+  }
+  
+  candidates, mimetype, err := server.routeRequest(request)
+  if err != nil {
+    fmt.Printf("  Could not map resource: %v\n", err)
+    return
+  }
+  
+  match := matchRoutes(server.rules, u.Path)
+  
+  fmt.Printf("  Content type: %s\n", mimetype)
+  if len(match.Rules) > 0 {
+    fmt.Printf("  Matched routes, in order:\n")
+    for _, e := range match.Rules {
+      fmt.Printf("    - %v\n", e)
+    }
+  }
+  fmt.Printf("  Candidates, in order:\n")
+  
+  // the server serves the first file it can open, whether or not it can compile it
+  var found string
+  var supported bool
+  for i, e := range candidates {
+    var status string
+    if info, err := os.Stat(e); err != nil {
+      status = "missing"
+    }else if info.IsDir() {
+      status = "directory"
+    }else if file, err := os.Open(e); err != nil {
+      status = fmt.Sprintf("exists, could not open: %v", err)
+    }else{
+      file.Close()
+      compiler, err := NewCompiler(NewContextWithOptions(options), e)
+      if err != nil {
+        status = fmt.Sprintf("exists, not supported: %v", err)
+      }else{
+        status = fmt.Sprintf("exists, compiled by %s", describeCompiler(compiler))
+      }
+      if found == "" {
+        found = e
+        supported = err == nil
+        status += " \u2190 served"
+      }
+    }
+    if p := options.ExcludePattern(e); p != "" {
+      status += fmt.Sprintf("; excluded from builds by '%s'", p)
+    }
+    fmt.Printf("    %d. %s (%s)\n", i + 1, e, status)
+  }
+  
+  local := server.proxy == nil || match.NoProxy || (request.Method == "GET" && CanCompile(nil, u.Path))
+  
+  if server.proxy == nil {
+    if found != "" {
+      describeServed(found, supported)
+    }else if fallback := server.fallbackDocument(request); fallback != "" {
+      fmt.Printf("  Not found; falls back to the index document: %s\n", fallback)
+    }else{
+      fmt.Printf("  Not found; no proxy is configured.\n")
+    }
+  }else if local {
+    if found != "" {
+      describeServed(found, supported)
+    }else if match.NoProxy {
+      fmt.Printf("  Not found; a matching route is never proxied.\n")
+    }else if server.strict {
+      fmt.Printf("  Not found; the server is strict and will not proxy managed resources.\n")
+    }else{
+      fmt.Printf("  Not found locally; falls through to the proxy: %v\n", proxyURL(server.peer, u))
+    }
+  }else{
+    fmt.Printf("  Unmanaged; proxied to: %v\n", proxyURL(server.peer, u))
+    if found != "" {
+      if supported {
+        fmt.Printf("  If the proxy responds 404, served locally from: %s\n", found)
+      }else{
+        fmt.Printf("  If the proxy responds 404, not supported locally (400 Bad Request): %s\n", found)
+      }
+    }
+  }
+  
+}
+
+/**
+ * Describe the local resource a request is served from
+ */
+func describeServed(found string, supported bool) {
+  if supported {
+    fmt.Printf("  Served locally from: %s\n", found)
+  }else{
+    fmt.Printf("  Not supported locally (400 Bad Request): %s\n", found)
+  }
+}
+
+/**
+ * Describe a compiler
+ */
+func describeCompiler(compiler Compiler) string {
+  switch c := compiler.(type) {
+    case CompilerChain:
+      names := make([]string, len(c))
+      for i, e := range c {
+        names[i] = describeCompiler(e)
+      }
+      return strings.Join(names, " \u2192 ")
+    default:
+      return strings.TrimPrefix(strings.TrimPrefix(fmt.Sprintf("%T", compiler), "*"), "main.")
+  }
+}
@@ -0,0 +1,316 @@
+// 
+// Copyright (c) 2014 Brian William Wolter, All rights reserved.
+// Slang
+// 
+// Redistribution and use in source and binary forms, with or without modification,
+// are permitted provided that the following conditions are met:
+// 
+//   * Redistributions of source code must retain the above copyright notice, this
+//     list of conditions and the following disclaimer.
+// 
+//   * Redistributions in binary form must reproduce the above copyright notice,
+//     this list of conditions and the following disclaimer in the documentation
+//     and/or other materials provided with the distribution.
+//     
+//   * Neither the names of Brian William Wolter, Wolter Group New York, nor the
+//     names of its contributors may be used to endorse or promote products derived
+//     from this software without specific prior written permission.
+//     
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+// INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING,
+// BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF
+// LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE
+// OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+// OF THE POSSIBILITY OF SUCH DAMAGE.
+// 
+package main
+
+import (
+  "os"
+  "fmt"
+  "path"
+  "sort"
+  "strings"
+  "io/ioutil"
+  "path/filepath"
+)
+
+import "ejs"
+
+import (
+  "net/url"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+)
+
+const (
+  VENDOR_PATH_DEFAULT     = "./vendor"
+  VENDOR_MANIFEST         = "vendor.json"
+)
+
+/**
+ * The vendor manifest, which records where each vendored file came from and what
+ * it contained when it was vendored so we can tell if it has been modified since
+ */
+type vendorManifest struct {
+  Files     map[string]vendorEntry  `json:"files"`
+}
+
+/**
+ * A vendored file
+ */
+type vendorEntry struct {
+  URL       string                  `json:"url"`
+  Integrity string                  `json:"integrity"`
+}
+
+/**
+ * A remote import found in a source file
+ */
+type remoteImport struct {
+  URL       string
+  Integrity string
+  Start     int
+  End       int
+}
+
+/**
+ * Find every remote import in the EJS sources under a root
+ */
+func findRemoteImports(root string) (map[string][]remoteImport, error) {
+  found := make(map[string][]remoteImport)
+  
+  err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+    if err != nil {
+      return err
+    }
+    hidden := p != root && strings.HasPrefix(info.Name(), ".")
+    if info.IsDir() {
+      if hidden { return filepath.SkipDir }else{ return nil }
+    }else if hidden || filepath.Ext(p) != ".ejs" {
+      return nil
+    }
+    
+    source, err := ioutil.ReadFile(p)
+    if err != nil {
+      return err
+    }
+    
+    scanner := newEJSScanner(SharedOptions(), p, string(source))
+    for {
+      toks, err := scanner.Token()
+      if err != nil {
+        return err
+      }
+      for _, tok := range toks {
+        if tok.Type == ejs.TokenTypeEOF {
+          return nil
+        }else if tok.Type == ejs.TokenTypeImport && isRemoteImport(tok.Text) {
+          start, end := scanner.DirectiveRange()
+          found[p] = append(found[p], remoteImport{tok.Text, tok.Integrity, start, end})
+        }
+      }
+    }
+  })
+  
+  return found, err
+}
+
+/**
+ * Determine the path under the vendor directory a URL is vendored to, which is
+ * made up of its host and path. A query, if any, is summarized in the name.
+ */
+func vendorPath(rawurl string) (string, error) {
+  u, err := url.Parse(rawurl)
+  if err != nil {
+    return "", err
+  }
+  
+  p := path.Clean("/"+ u.Path)
+  if p == "/" || strings.HasSuffix(u.Path, "/") {
+    p = path.Join(p, "index.js")
+  }
+  if u.RawQuery != "" {
+    sum := sha256.Sum256([]byte(u.RawQuery))
+    ext := path.Ext(p)
+    p = p[:len(p)-len(ext)] +"-"+ hex.EncodeToString(sum[:4]) + ext
+  }
+  
+  return strings.Replace(u.Host, ":", "_", -1) + p, nil
+}
+
+/**
+ * Load the vendor manifest
+ */
+func loadVendorManifest(root string) (*vendorManifest, error) {
+  manifest := &vendorManifest{}
+  if data, err := ioutil.ReadFile(filepath.Join(root, VENDOR_MANIFEST)); err != nil && !os.IsNotExist(err) {
+    return nil, err
+  }else if err == nil {
+    if err := json.Unmarshal(data, manifest); err != nil {
+      return nil, fmt.Errorf("Vendor manifest is not valid: %v", err)
+    }
+  }
+  if manifest.Files == nil {
+    manifest.Files = make(map[string]vendorEntry)
+  }
+  return manifest, nil
+}
+
+/**
+ * Vendor a remote import. The vendored file is only replaced if it is unchanged
+ * since it was vendored, unless we're forced.
+ */
+func vendorImport(options VendorOptions, manifest *vendorManifest, rawurl, integrity string) (string, string, error) {
+  rel, err := vendorPath(rawurl)
+  if err != nil {
+    return "", "", err
+  }
+  
+  data, err := sharedRemoteImports(SharedOptions().Imports).fetch(rawurl, integrity)
+  if err != nil {
+    return "", "", err
+  }
+  
+  dest := filepath.Join(options.Path, filepath.FromSlash(rel))
+  status := "added"
+  
+  if current, err := ioutil.ReadFile(dest); err != nil && !os.IsNotExist(err) {
+    return "", "", err
+  }else if err == nil {
+    entry, known := manifest.Files[rel]
+    if string(current) == string(data) {
+      status = "unchanged"
+    }else if !options.Force && (!known || importIntegrity(current) != entry.Integrity) {
+      return dest, "", fmt.Errorf("Vendored file has local modifications; use -force to overwrite it: %s", dest)
+    }else{
+      status = "updated"
+    }
+  }
+  
+  if status != "unchanged" {
+    if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+      return "", "", err
+    }else if err := writeOutput(dest, data); err != nil {
+      return "", "", err
+    }
+  }
+  
+  manifest.Files[rel] = vendorEntry{rawurl, importIntegrity(data)}
+  return dest, status, nil
+}
+
+/**
+ * Rewrite the remote imports in a source file to refer to their vendored copies
+ */
+func rewriteImports(file string, imports []remoteImport, vendored map[string]string) (int, error) {
+  source, err := ioutil.ReadFile(file)
+  if err != nil {
+    return 0, err
+  }
+  
+  // work backwards so earlier ranges remain valid
+  sort.Slice(imports, func(i, j int) bool { return imports[i].Start > imports[j].Start })
+  
+  var count int
+  text := string(source)
+  for _, e := range imports {
+    dest, ok := vendored[e.URL]
+    if !ok {
+      continue // could not be vendored
+    }
+    rel, err := filepath.Rel(filepath.Dir(file), dest)
+    if err != nil {
+      return 0, err
+    }
+    text = text[:e.Start] + fmt.Sprintf("#import %q", filepath.ToSlash(rel)) + text[e.End:]
+    count++
+  }
+  
+  if count > 0 {
+    if err := writeOutput(file, []byte(text)); err != nil {
+      return 0, err
+    }
+  }
+  
+  return count, nil
+}
+
+/**
+ * Vendor every remote import in a source tree
+ */
+func runVendor(options *Options, args []string) int {
+  var diagnostics Diagnostics
+  quiet := options.GetFlag(OptionsFlagQuiet)
+  
+  found, err := findRemoteImports(serverRoot(options, args))
+  if err != nil {
+    printDiagnostics(DIAGNOSTIC_FORMAT_TEXT, Diagnostics{NewDiagnostic(SeverityError, "", err)}, nil)
+    return EXIT_COMPILE
+  }
+  
+  manifest, err := loadVendorManifest(options.Vendor.Path)
+  if err != nil {
+    fmt.Println(err)
+    return EXIT_IO
+  }
+  
+  // vendor each distinct import once, in a stable order
+  files := make([]string, 0, len(found))
+  for k := range found {
+    files = append(files, k)
+  }
+  sort.Strings(files)
+  
+  vendored := make(map[string]string)
+  attempted := make(map[string]struct{})
+  for _, f := range files {
+    for _, e := range found[f] {
+      if _, ok := attempted[e.URL]; ok {
+        continue
+      }
+      attempted[e.URL] = struct{}{}
+      dest, status, err := vendorImport(options.Vendor, manifest, e.URL, e.Integrity)
+      if err != nil {
+        d := NewDiagnostic(SeverityError, f, err)
+        if dest != "" {
+          d.Code = DiagnosticCodeModified
+        }
+        diagnostics = append(diagnostics, d)
+        continue
+      }
+      vendored[e.URL] = dest
+      if !quiet { fmt.Printf("[v] %s → %s (%s)\n", e.URL, dest, status) }
+    }
+  }
+  
+  if len(vendored) > 0 {
+    if data, err := json.MarshalIndent(manifest, "", "  "); err != nil {
+      diagnostics = append(diagnostics, NewDiagnostic(SeverityError, "", err))
+    }else if err := writeOutput(filepath.Join(options.Vendor.Path, VENDOR_MANIFEST), append(data, '\n')); err != nil {
+      diagnostics = append(diagnostics, NewDiagnostic(SeverityError, "", err))
+    }
+  }
+  
+  if options.Vendor.Rewrite {
+    for _, f := range files {
+      if n, err := rewriteImports(f, found[f], vendored); err != nil {
+        diagnostics = append(diagnostics, NewDiagnostic(SeverityError, f, err))
+      }else if n > 0 && !quiet {
+        fmt.Printf("[r] %s (%d imports)\n", f, n)
+      }
+    }
+  }
+  
+  printDiagnostics(DIAGNOSTIC_FORMAT_TEXT, diagnostics, nil)
+  if !quiet || len(diagnostics) > 0 {
+    fmt.Printf("Vendored %d imports into %s; %s\n", len(vendored), options.Vendor.Path, diagnostics.Summary())
+  }
+  
+  return diagnostics.ExitStatus()
+}